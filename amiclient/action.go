@@ -2,6 +2,7 @@ package amiclient
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 )
 
@@ -9,22 +10,30 @@ import (
 var (
 	actionDelimiterS = []byte{':', ' '}
 	lineTerm         = []byte{'\n'}
-	actionDelimiterB = []byte(": ")
+	headerDelimiter  = []byte{':'}
+	space            = []byte{' '}
+	responseFollows  = []byte("Response: Follows")
+	privilegeHeader  = []byte("Privilege: ")
+	actionIDHeader   = []byte("ActionID: ")
+	endCommand       = []byte("--END COMMAND--")
 )
 
+// Action and Message were map[string]string before ordered headers. The change breaks index
+// expressions (msg["Uniqueid"]), make(Action) and map literals: use Get, NewAction with Add,
+// and ActionFromMap, MessageFromMap and Headers.Map to convert from and to the old maps.
 type (
 	ActionKey []byte
-	Action    map[string]string
-	Message   map[string]string
+	Action    = Headers
+	Message   = Headers
 )
 
-func (a Action) Serialize() []byte {
+func (h Headers) Serialize() []byte {
 	var command bytes.Buffer
 
-	for key := range a {
-		command.WriteString(key)
+	for _, header := range h {
+		command.WriteString(header.Key)
 		command.Write(actionDelimiterS)
-		command.WriteString(a[key])
+		command.WriteString(header.Value)
 		command.Write(lineTerm)
 	}
 
@@ -33,48 +42,92 @@ func (a Action) Serialize() []byte {
 	return command.Bytes()
 }
 
-func ParseAction(buf bytes.Buffer) Action {
-	event := make(Action)
+// SerializeWire is Serialize writing a "Response: Follows" message as Asterisk does: the Output
+// headers become the command body ended by --END COMMAND--, so Parser reads the same message back.
+func (h Headers) SerializeWire() []byte {
+	if len(h) == 0 || h[0].Key != "Response" || h[0].Value != "Follows" {
+		return h.Serialize()
+	}
 
-	for {
-		line, err := buf.ReadBytes('\n')
-		line = bytes.TrimSuffix(line, lineTerm)
+	var command, output bytes.Buffer
 
-		if (err != io.EOF && err != nil) || len(line) == 0 {
-			break
-		}
+	for _, header := range h {
+		if header.Key == "Output" {
+			output.WriteString(header.Value)
+			output.Write(lineTerm)
 
-		vMap := bytes.Split(line, actionDelimiterB)
+			continue
+		}
 
-		event[string(vMap[0])] = string(vMap[1])
+		command.WriteString(header.Key)
+		command.Write(actionDelimiterS)
+		command.WriteString(header.Value)
+		command.Write(lineTerm)
 	}
 
-	return event
+	command.Write(output.Bytes())
+
+	command.Write(endCommand)
+	command.Write(lineTerm)
+	command.Write(lineTerm)
+
+	return command.Bytes()
 }
 
+// ParseAction parses an action, see ParseMessage.
+func ParseAction(buf bytes.Buffer) Action {
+	action, _ := parseHeaders(buf, false)
+
+	return action
+}
+
+// ParseMessage parses headers up to the first empty line. A line without a colon
+// becomes a header with an empty value, use ParseMessageStrict to reject it.
 func ParseMessage(buf bytes.Buffer) Message {
-	event := make(Message)
+	msg, _ := parseHeaders(buf, false)
+
+	return msg
+}
+
+// ParseMessageStrict is ParseMessage failing with ErrMalformedHeader on a line without a colon.
+func ParseMessageStrict(buf bytes.Buffer) (Message, error) {
+	return parseHeaders(buf, true)
+}
+
+func parseHeaders(buf bytes.Buffer, strict bool) (Headers, error) {
+	headers := make(Headers, 0)
 
 	for {
 		line, err := buf.ReadBytes('\n')
-		line = bytes.TrimSuffix(line, lineTerm)
+		line = trimEOL(line)
 
 		if (err != io.EOF && err != nil) || len(line) == 0 {
 			break
 		}
 
-		vMap := bytes.Split(line, actionDelimiterB)
+		key, value, ok := splitHeader(line)
+		if !ok && strict {
+			return nil, malformedHeader(line)
+		}
 
-		event[string(vMap[0])] = getMsgValue(vMap)
+		headers.Add(string(key), string(value))
 	}
 
-	return event
+	return headers, nil
 }
 
-func getMsgValue(vMap [][]byte) string {
-	if len(vMap) == 1 {
-		return ""
+// splitHeader splits the line on the first colon, so values may contain ": ". Spaces around
+// the key and one space before the value are dropped, "Key:value" is accepted as well.
+// A line without a colon is returned as the trimmed key with ok set to false.
+func splitHeader(line []byte) (key, value []byte, ok bool) {
+	key, value, ok = bytes.Cut(line, headerDelimiter)
+	if !ok {
+		return bytes.TrimSpace(line), nil, false
 	}
 
-	return string(vMap[1])
+	return bytes.TrimSpace(key), bytes.TrimPrefix(value, space), true
+}
+
+func malformedHeader(line []byte) error {
+	return fmt.Errorf("%w: %q", ErrMalformedHeader, line)
 }
@@ -0,0 +1,73 @@
+package actions
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Arten331/telephony/amiclient"
+)
+
+var ErrInvalidAction = errors.New("invalid action")
+
+// Action is a typed AMI action, fields are marshaled with amiclient.Marshal.
+type Action interface {
+	ActionName() string
+}
+
+type validator interface {
+	Validate() error
+}
+
+// builder is implemented by the actions adding headers their fields cannot express.
+type builder interface {
+	build(action *amiclient.Action)
+}
+
+type Doer interface {
+	Do(ctx context.Context, action amiclient.Action) (amiclient.Message, error)
+}
+
+// Response holds the headers every action reply has.
+type Response struct {
+	Response string
+	Message  string
+	ActionID string
+}
+
+// Build validates the action and converts it to amiclient.Action.
+func Build(a Action) (amiclient.Action, error) {
+	if v, ok := a.(validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	action, err := amiclient.Marshal(a.ActionName(), a)
+	if err != nil {
+		return nil, err
+	}
+
+	if b, ok := a.(builder); ok {
+		b.build(&action)
+	}
+
+	return action, nil
+}
+
+// Do sends the action and decodes the reply into resp, resp may be nil.
+// On Response: Error the reply is still decoded and the amiclient.ErrActionFailed error returned.
+func Do(ctx context.Context, c Doer, a Action, resp interface{}) error {
+	action, err := Build(a)
+	if err != nil {
+		return err
+	}
+
+	msg, err := c.Do(ctx, action)
+	if msg != nil && resp != nil {
+		if errDecode := amiclient.Unmarshal(msg, resp); errDecode != nil && err == nil {
+			err = errDecode
+		}
+	}
+
+	return err
+}
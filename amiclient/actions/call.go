@@ -0,0 +1,160 @@
+package actions
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Arten331/telephony/amiclient"
+)
+
+// Originate dials Channel and connects it to Exten/Context/Priority or to Application.
+// Timeout is in milliseconds. Originate is sent with Async: true unless Sync is set, the
+// call result comes with the OriginateResponse event then.
+type Originate struct {
+	Channel        string            `ami:",required"`
+	Exten          string            `ami:",omitempty"`
+	Context        string            `ami:",omitempty"`
+	Priority       int               `ami:",omitempty"`
+	Application    string            `ami:",omitempty"`
+	Data           string            `ami:",omitempty"`
+	Timeout        int               `ami:",omitempty"`
+	CallerID       string            `ami:",omitempty"`
+	Variable       map[string]string `ami:",omitempty"`
+	Account        string            `ami:",omitempty"`
+	EarlyMedia     bool              `ami:",omitempty"`
+	Codecs         string            `ami:",omitempty"`
+	ChannelID      string            `ami:"ChannelId,omitempty"`
+	OtherChannelID string            `ami:"OtherChannelId,omitempty"`
+	// Sync makes Asterisk answer only after the call is answered or failed, Do blocks up to Timeout.
+	Sync bool `ami:"-"`
+}
+
+func (Originate) ActionName() string { return "Originate" }
+
+func (a Originate) build(action *amiclient.Action) {
+	action.Set("Async", strconv.FormatBool(!a.Sync))
+}
+
+func (a Originate) Validate() error {
+	dialplan := a.Exten != "" || a.Context != ""
+
+	switch {
+	case dialplan && a.Application != "":
+		return fmt.Errorf("%w: Originate needs either Exten/Context/Priority or Application", ErrInvalidAction)
+	case dialplan && (a.Exten == "" || a.Context == "" || a.Priority == 0):
+		return fmt.Errorf("%w: Originate needs Exten, Context and Priority together", ErrInvalidAction)
+	case !dialplan && a.Application == "":
+		return fmt.Errorf("%w: Originate needs Exten/Context/Priority or Application", ErrInvalidAction)
+	}
+
+	return nil
+}
+
+type Hangup struct {
+	Channel string `ami:",required"`
+	Cause   int    `ami:",omitempty"`
+}
+
+func (Hangup) ActionName() string { return "Hangup" }
+
+type Redirect struct {
+	Channel       string `ami:",required"`
+	ExtraChannel  string `ami:",omitempty"`
+	Exten         string `ami:",required"`
+	ExtraExten    string `ami:",omitempty"`
+	Context       string `ami:",required"`
+	ExtraContext  string `ami:",omitempty"`
+	Priority      int    `ami:",required"`
+	ExtraPriority int    `ami:",omitempty"`
+}
+
+func (Redirect) ActionName() string { return "Redirect" }
+
+type Atxfer struct {
+	Channel string `ami:",required"`
+	Exten   string `ami:",required"`
+	Context string `ami:",omitempty"`
+}
+
+func (Atxfer) ActionName() string { return "Atxfer" }
+
+type Bridge struct {
+	Channel1 string `ami:",required"`
+	Channel2 string `ami:",required"`
+	Tone     string `ami:",omitempty"`
+}
+
+func (Bridge) ActionName() string { return "Bridge" }
+
+// Setvar sets a channel variable, a global variable when Channel is empty.
+type Setvar struct {
+	Channel  string `ami:",omitempty"`
+	Variable string `ami:",required"`
+	Value    string
+}
+
+func (Setvar) ActionName() string { return "Setvar" }
+
+type Getvar struct {
+	Channel  string `ami:",omitempty"`
+	Variable string `ami:",required"`
+}
+
+func (Getvar) ActionName() string { return "Getvar" }
+
+type GetvarResponse struct {
+	Response
+	Variable string
+	Value    string
+}
+
+type PlayDTMF struct {
+	Channel  string `ami:",required"`
+	Digit    string `ami:",required"`
+	Duration int    `ami:",omitempty"`
+	Receive  bool   `ami:",omitempty"`
+}
+
+func (PlayDTMF) ActionName() string { return "PlayDTMF" }
+
+func (a PlayDTMF) Validate() error {
+	if len(a.Digit) != 1 {
+		return fmt.Errorf("%w: PlayDTMF Digit must be a single digit", ErrInvalidAction)
+	}
+
+	return nil
+}
+
+// MixMonitor starts recording, Options are the MixMonitor application options.
+type MixMonitor struct {
+	Channel string `ami:",required"`
+	File    string `ami:",omitempty"`
+	Options string `ami:",omitempty"`
+	Command string `ami:",omitempty"`
+}
+
+func (MixMonitor) ActionName() string { return "MixMonitor" }
+
+type MixMonitorResponse struct {
+	Response
+	MixMonitorID string
+}
+
+type StopMixMonitor struct {
+	Channel      string `ami:",required"`
+	MixMonitorID string `ami:",omitempty"`
+}
+
+func (StopMixMonitor) ActionName() string { return "StopMixMonitor" }
+
+// Replies of the call actions without headers of their own, Getvar and MixMonitor have theirs.
+type (
+	OriginateResponse      struct{ Response }
+	HangupResponse         struct{ Response }
+	RedirectResponse       struct{ Response }
+	AtxferResponse         struct{ Response }
+	BridgeResponse         struct{ Response }
+	SetvarResponse         struct{ Response }
+	PlayDTMFResponse       struct{ Response }
+	StopMixMonitorResponse struct{ Response }
+)
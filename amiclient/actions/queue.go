@@ -0,0 +1,36 @@
+package actions
+
+type QueueAdd struct {
+	Queue          string `ami:",required"`
+	Interface      string `ami:",required"`
+	Penalty        int    `ami:",omitempty"`
+	Paused         bool
+	MemberName     string `ami:",omitempty"`
+	StateInterface string `ami:",omitempty"`
+}
+
+func (QueueAdd) ActionName() string { return "QueueAdd" }
+
+type QueueRemove struct {
+	Queue     string `ami:",required"`
+	Interface string `ami:",required"`
+}
+
+func (QueueRemove) ActionName() string { return "QueueRemove" }
+
+// QueuePause pauses or unpauses the member in Queue, in all queues when Queue is empty.
+type QueuePause struct {
+	Interface string `ami:",required"`
+	Paused    bool
+	Queue     string `ami:",omitempty"`
+	Reason    string `ami:",omitempty"`
+}
+
+func (QueuePause) ActionName() string { return "QueuePause" }
+
+// Replies of the queue actions.
+type (
+	QueueAddResponse    struct{ Response }
+	QueueRemoveResponse struct{ Response }
+	QueuePauseResponse  struct{ Response }
+)
@@ -0,0 +1,48 @@
+package actions
+
+import (
+	"fmt"
+)
+
+// Reload reloads the module, everything when Module is empty.
+type Reload struct {
+	Module string `ami:",omitempty"`
+}
+
+func (Reload) ActionName() string { return "Reload" }
+
+type LoadType string
+
+const (
+	LoadTypeLoad   LoadType = "load"
+	LoadTypeUnload LoadType = "unload"
+	LoadTypeReload LoadType = "reload"
+)
+
+// ModuleLoad loads, unloads or reloads the module. Module may be empty only for reload.
+type ModuleLoad struct {
+	Module   string   `ami:",omitempty"`
+	LoadType LoadType `ami:",required"`
+}
+
+func (ModuleLoad) ActionName() string { return "ModuleLoad" }
+
+func (a ModuleLoad) Validate() error {
+	switch a.LoadType {
+	case LoadTypeLoad, LoadTypeUnload:
+		if a.Module == "" {
+			return fmt.Errorf("%w: ModuleLoad %s needs Module", ErrInvalidAction, a.LoadType)
+		}
+	case LoadTypeReload:
+	default:
+		return fmt.Errorf("%w: unknown ModuleLoad LoadType %q", ErrInvalidAction, a.LoadType)
+	}
+
+	return nil
+}
+
+// Replies of the system actions.
+type (
+	ReloadResponse     struct{ Response }
+	ModuleLoadResponse struct{ Response }
+)
@@ -0,0 +1,535 @@
+// Package amitest provides a scriptable in-process fake Asterisk Manager Interface server for tests.
+package amitest
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5" //nolint:gosec // AMI challenge
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient"
+)
+
+const defaultConnectionTimeout = 5 * time.Second
+
+const (
+	DefaultBanner    = "Asterisk Call Manager/5.0.2"
+	DefaultUsername  = "test"
+	DefaultSecret    = "test"
+	DefaultChallenge = "840415273"
+)
+
+type Settings struct {
+	Banner    string
+	Username  string
+	Secret    string
+	Challenge string
+	TLSConfig *tls.Config
+}
+
+// Handler answers an action, it is called from the connection goroutine.
+type Handler func(c *Conn, action amiclient.Action)
+
+// Server is a fake Asterisk listening on a random local port. Login, Challenge, Logoff, Ping,
+// Events and Filter are handled by default, other actions answer Response: Error until a
+// handler is registered. Every received action is recorded.
+type Server struct {
+	settings Settings
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	conns    map[*Conn]struct{}
+	received []amiclient.Action
+	notify   chan struct{}
+	closed   bool
+}
+
+// NewServer starts the server, empty settings fields get the Default values.
+func NewServer(cfg *Settings) (*Server, error) {
+	s := &Server{
+		handlers: make(map[string]Handler),
+		conns:    make(map[*Conn]struct{}),
+		notify:   make(chan struct{}),
+	}
+
+	if cfg != nil {
+		s.settings = *cfg
+	}
+
+	s.setDefaults()
+
+	var err error
+
+	if s.settings.TLSConfig != nil {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.settings.TLSConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.Handle("Login", s.login)
+	s.Handle("Challenge", s.challenge)
+	s.Handle("Logoff", logoff)
+	s.Handle("Ping", ping)
+	s.Handle("Events", Respond(amiclient.Message{{Key: "Response", Value: "Success"}, {Key: "Events", Value: "On"}}))
+	s.Handle("Filter", Respond(Success("Filter Added Successfully")))
+
+	s.wg.Add(1)
+
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *Server) setDefaults() {
+	if s.settings.Banner == "" {
+		s.settings.Banner = DefaultBanner
+	}
+
+	if s.settings.Username == "" {
+		s.settings.Username = DefaultUsername
+	}
+
+	if s.settings.Secret == "" {
+		s.settings.Secret = DefaultSecret
+	}
+
+	if s.settings.Challenge == "" {
+		s.settings.Challenge = DefaultChallenge
+	}
+}
+
+func (s *Server) Addr() *net.TCPAddr {
+	return s.listener.Addr().(*net.TCPAddr) //nolint:forcetypeassert // tcp listener
+}
+
+func (s *Server) Port() int {
+	return s.Addr().Port
+}
+
+// ClientSettings returns client settings pointing to the server with its credentials.
+func (s *Server) ClientSettings() *amiclient.Settings {
+	return &amiclient.Settings{
+		Host:              s.Addr().IP.String(),
+		Port:              s.Port(),
+		Username:          s.settings.Username,
+		Password:          s.settings.Secret,
+		ConnectionTimeout: defaultConnectionTimeout,
+	}
+}
+
+// Handle registers the handler of the action, the name is matched case-insensitively.
+func (s *Server) Handle(action string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[strings.ToLower(action)] = h
+}
+
+func (s *Server) handler(action string) Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.handlers[strings.ToLower(action)]
+}
+
+// Emit sends the event to every logged in connection.
+func (s *Server) Emit(event amiclient.Message) {
+	for _, c := range s.connections() {
+		if c.Authenticated() {
+			_ = c.Send(event)
+		}
+	}
+}
+
+// Drop closes all client connections, the server keeps accepting new ones.
+func (s *Server) Drop() {
+	for _, c := range s.connections() {
+		_ = c.Close()
+	}
+}
+
+// Connections returns the number of open connections.
+func (s *Server) Connections() int {
+	return len(s.connections())
+}
+
+func (s *Server) connections() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+
+	return conns
+}
+
+// Received returns the actions received so far by all connections in arrival order.
+func (s *Server) Received() []amiclient.Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]amiclient.Action(nil), s.received...)
+}
+
+// ReceivedActions returns the received actions with the given name.
+func (s *Server) ReceivedActions(name string) []amiclient.Action {
+	var actions []amiclient.Action
+
+	for _, a := range s.Received() {
+		if strings.EqualFold(a.Get("Action"), name) {
+			actions = append(actions, a)
+		}
+	}
+
+	return actions
+}
+
+// WaitAction waits until an action with the given name is received and returns the first one.
+func (s *Server) WaitAction(ctx context.Context, name string) (amiclient.Action, error) {
+	for {
+		s.mu.Lock()
+		notify := s.notify
+		s.mu.Unlock()
+
+		if actions := s.ReceivedActions(name); len(actions) != 0 {
+			return actions[0], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+func (s *Server) record(action amiclient.Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.received = append(s.received, action)
+
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// Close stops the listener, closes the connections and waits for their goroutines.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+
+	s.Drop()
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &Conn{server: s, conn: conn}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+
+			_ = conn.Close()
+
+			return
+		}
+
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go c.serve()
+	}
+}
+
+func (s *Server) login(c *Conn, action amiclient.Action) {
+	ok := action.Get("Username") == s.settings.Username
+
+	if strings.EqualFold(action.Get("AuthType"), "MD5") {
+		ok = ok && action.Get("Key") == md5Key(s.settings.Challenge+s.settings.Secret)
+	} else {
+		ok = ok && action.Get("Secret") == s.settings.Secret
+	}
+
+	if !ok {
+		c.Respond(action, Error("Authentication failed"))
+
+		return
+	}
+
+	c.setAuthenticated()
+	c.Respond(action, Success("Authentication accepted"))
+	_ = c.Send(amiclient.Message{
+		{Key: "Event", Value: "FullyBooted"},
+		{Key: "Privilege", Value: "system,all"},
+		{Key: "Status", Value: "Fully Booted"},
+	})
+}
+
+func (s *Server) challenge(c *Conn, action amiclient.Action) {
+	c.Respond(action, amiclient.Message{
+		{Key: "Response", Value: "Success"},
+		{Key: "Challenge", Value: s.settings.Challenge},
+	})
+}
+
+func logoff(c *Conn, action amiclient.Action) {
+	c.Respond(action, amiclient.Message{
+		{Key: "Response", Value: "Goodbye"},
+		{Key: "Message", Value: "Thanks for all the fish."},
+	})
+
+	_ = c.Close()
+}
+
+func ping(c *Conn, action amiclient.Action) {
+	c.Respond(action, amiclient.Message{
+		{Key: "Response", Value: "Success"},
+		{Key: "Ping", Value: "Pong"},
+		{Key: "Timestamp", Value: "1651218111.244400"},
+	})
+}
+
+func md5Key(s string) string {
+	sum := md5.Sum([]byte(s)) //nolint:gosec // AMI challenge
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Success returns Response: Success with the message.
+func Success(message string) amiclient.Message {
+	return amiclient.Message{{Key: "Response", Value: "Success"}, {Key: "Message", Value: message}}
+}
+
+// Error returns Response: Error with the message.
+func Error(message string) amiclient.Message {
+	return amiclient.Message{{Key: "Response", Value: "Error"}, {Key: "Message", Value: message}}
+}
+
+// Respond answers every action with the response.
+func Respond(response amiclient.Message) Handler {
+	return func(c *Conn, action amiclient.Action) {
+		c.Respond(action, response)
+	}
+}
+
+// NoResponse leaves actions unanswered, for timeout tests.
+func NoResponse(*Conn, amiclient.Action) {}
+
+// DropConnection closes the connection without a response.
+func DropConnection(c *Conn, _ amiclient.Action) {
+	_ = c.Close()
+}
+
+// List simulates an EventList action: the start response, one event per item
+// and the completion event named itemEvent+"Complete" with ListItems.
+func List(itemEvent string, items ...amiclient.Message) Handler {
+	events := make([]amiclient.Message, 0, len(items))
+	for _, item := range items {
+		events = append(events, append(amiclient.Message{{Key: "Event", Value: itemEvent}}, item...))
+	}
+
+	return ListEvents(itemEvent+"Complete", events...)
+}
+
+// ListEvents is List for actions mixing several item events, like QueueStatus.
+// The events must have the Event header.
+func ListEvents(completeEvent string, events ...amiclient.Message) Handler {
+	return func(c *Conn, action amiclient.Action) {
+		c.Respond(action, amiclient.Message{
+			{Key: "Response", Value: "Success"},
+			{Key: "EventList", Value: "start"},
+			{Key: "Message", Value: "Events will follow"},
+		})
+
+		id := action.Get("ActionID")
+
+		for _, e := range events {
+			event := e.Clone()
+			if id != "" {
+				event.Add("ActionID", id)
+			}
+
+			_ = c.Send(event)
+		}
+
+		complete := amiclient.Message{
+			{Key: "Event", Value: completeEvent},
+			{Key: "EventList", Value: "Complete"},
+			{Key: "ListItems", Value: strconv.Itoa(len(events))},
+		}
+		if id != "" {
+			complete.Add("ActionID", id)
+		}
+
+		_ = c.Send(complete)
+	}
+}
+
+// Conn is a client connection of the server.
+type Conn struct {
+	server *Server
+	conn   net.Conn
+
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	authenticated bool
+	received      []amiclient.Action
+}
+
+func (c *Conn) serve() {
+	defer c.server.wg.Done()
+
+	defer func() {
+		_ = c.conn.Close()
+
+		c.server.mu.Lock()
+		delete(c.server.conns, c)
+		c.server.mu.Unlock()
+	}()
+
+	err := c.write([]byte(c.server.settings.Banner + "\r\n"))
+	if err != nil {
+		return
+	}
+
+	parser := amiclient.NewParser(bufio.NewReader(c.conn))
+
+	for {
+		action, err := parser.Next()
+		if err != nil {
+			return
+		}
+
+		if len(action) == 0 {
+			continue
+		}
+
+		c.record(action)
+		c.server.record(action)
+		c.handle(action)
+	}
+}
+
+func (c *Conn) handle(action amiclient.Action) {
+	name := action.Get("Action")
+
+	if !c.Authenticated() && !strings.EqualFold(name, "Login") && !strings.EqualFold(name, "Challenge") {
+		c.Respond(action, Error("Authentication Required"))
+
+		return
+	}
+
+	h := c.server.handler(name)
+	if h == nil {
+		c.Respond(action, Error("Invalid/unknown command"))
+
+		return
+	}
+
+	h(c, action)
+}
+
+// Send writes the messages to the client, a "Response: Follows" message gets its Output
+// headers as the command body.
+func (c *Conn) Send(msgs ...amiclient.Message) error {
+	for _, msg := range msgs {
+		if err := c.write(msg.SerializeWire()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Respond sends the response with the ActionID of the action.
+func (c *Conn) Respond(action amiclient.Action, response amiclient.Message) {
+	response = response.Clone()
+
+	if id, ok := action.Lookup("ActionID"); ok {
+		response.Set("ActionID", id)
+	}
+
+	_ = c.Send(response)
+}
+
+func (c *Conn) write(b []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err := c.conn.Write(b)
+
+	return err
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) Authenticated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.authenticated
+}
+
+func (c *Conn) setAuthenticated() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.authenticated = true
+}
+
+// Received returns the actions received by the connection in arrival order, the current one included.
+func (c *Conn) Received() []amiclient.Action {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]amiclient.Action(nil), c.received...)
+}
+
+func (c *Conn) record(action amiclient.Action) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.received = append(c.received, action)
+}
+
+// Replay emits the received events of a recorded session to the logged in connections,
+// see amiclient.Replay for the speed. Responses are skipped: their ActionIDs belong to the
+// recorded session and could answer an action of the current one.
+func (s *Server) Replay(ctx context.Context, records []amiclient.Record, speed float64) error {
+	return amiclient.Replay(ctx, records, speed, func(record amiclient.Record) {
+		if record.Direction == amiclient.DirectionIn && record.Message.Has("Event") {
+			s.Emit(record.Message)
+		}
+	})
+}
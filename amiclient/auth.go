@@ -0,0 +1,133 @@
+package amiclient
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // required by AMI challenge auth
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/Arten331/observability/logger"
+	"go.uber.org/zap"
+)
+
+type AuthMethod string
+
+const (
+	AuthPlain AuthMethod = ""
+	AuthMD5   AuthMethod = "MD5"
+)
+
+func (c *Client) auth(ctx context.Context) error {
+	ctx, cancel := c.handshakeContext(ctx, time.Second*10)
+	defer cancel()
+
+	authCommand := NewAction("Login")
+	authCommand.Add("Username", c.settings.Username)
+
+	if mask := c.EventMask(); mask != "" {
+		authCommand.Add("Events", mask)
+	}
+
+	switch c.settings.AuthMethod {
+	case AuthMD5:
+		key, err := c.challenge(ctx)
+		if err != nil {
+			return err
+		}
+
+		authCommand.Add("AuthType", string(AuthMD5))
+		authCommand.Add("Key", key)
+	default:
+		authCommand.Add("Secret", c.settings.Password)
+	}
+
+	msg, err := c.handshake(ctx, authCommand)
+	if err != nil {
+		return err
+	}
+
+	if msg.Get("Response") != "Success" && msg.Get("Message") != "Authentication accepted" {
+		logger.S().Info("ami auth: receive message", msg)
+
+		return fmt.Errorf("%w: %s", ErrAuthFailed, msg.Get("Message"))
+	}
+
+	logger.L().Info("Authentication accepted, User: %s", zap.String("username", c.settings.Username))
+
+	return nil
+}
+
+// challenge requests an MD5 challenge and returns the Login key for it.
+func (c *Client) challenge(ctx context.Context) (string, error) {
+	challenge := NewAction("Challenge")
+	challenge.Add("AuthType", string(AuthMD5))
+
+	msg, err := c.handshake(ctx, challenge)
+	if err != nil {
+		return "", err
+	}
+
+	if msg.Get("Response") != "Success" || msg.Get("Challenge") == "" {
+		return "", fmt.Errorf("%w: %s", ErrChallengeFailed, msg.Get("Message"))
+	}
+
+	sum := md5.Sum([]byte(msg.Get("Challenge") + c.settings.Password)) //nolint:gosec // required by AMI challenge auth
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// handshakeContext bounds the synchronous reads done before the reader loop is started.
+func (c *Client) handshakeContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	conn := c.connection()
+	if conn == nil {
+		return ctx, cancel
+	}
+
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetReadDeadline(deadline)
+
+	return ctx, func() {
+		_ = conn.SetReadDeadline(time.Time{})
+		cancel()
+	}
+}
+
+// handshake sends the action before the connection is logged in and reads its response,
+// the reader loop is not running yet.
+func (c *Client) handshake(ctx context.Context, action Action) (Message, error) {
+	action, id := c.withActionID(action)
+
+	err := c.send(ctx, action, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.readResponse(ctx, id)
+}
+
+// readResponse reads until the response with the ActionID, other messages are skipped.
+func (c *Client) readResponse(ctx context.Context, id string) (Message, error) {
+	reader := c.connectionReader()
+
+	for {
+		msg, err := ReadMessage(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		c.settings.Recorder.Record(DirectionIn, msg)
+
+		if ctx.Err() != nil {
+			return nil, ErrAuthTimeOut
+		}
+
+		if msg.Has("Response") && msg.Get("ActionID") == id {
+			return msg, nil
+		}
+
+		releaseMessage(msg)
+	}
+}
@@ -0,0 +1,234 @@
+package amiclient
+
+import (
+	"sync"
+)
+
+const defaultSpillBufferSize = 1000
+
+// BackpressurePolicy decides what the reader does when a consumer channel is full.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock waits for the consumer, a slow consumer stalls the connection.
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureDropOldest discards the oldest queued message to make room.
+	BackpressureDropOldest
+	// BackpressureDropNewest discards the incoming message.
+	BackpressureDropNewest
+	// BackpressureSpill queues messages in a bounded ring buffer (Settings.SpillBufferSize)
+	// drained in background, the oldest spilled message is dropped when the ring is full.
+	BackpressureSpill
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BackpressureBlock:
+		return "block"
+	case BackpressureDropOldest:
+		return "drop_oldest"
+	case BackpressureDropNewest:
+		return "drop_newest"
+	case BackpressureSpill:
+		return "spill"
+	default:
+		return "unknown"
+	}
+}
+
+// sink delivers messages to a consumer channel according to the backpressure policy.
+type sink struct {
+	ch      chan Message
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.RWMutex
+	closed  bool
+	policy  BackpressurePolicy
+	metrics *Metrics
+	spill   *spillRing
+}
+
+func newSink(ch chan Message, policy BackpressurePolicy, spillSize int, metrics *Metrics) *sink {
+	s := &sink{
+		ch:      ch,
+		done:    make(chan struct{}),
+		policy:  policy,
+		metrics: metrics,
+	}
+
+	if policy == BackpressureSpill {
+		if spillSize <= 0 {
+			spillSize = defaultSpillBufferSize
+		}
+
+		s.spill = &spillRing{
+			buf:  make([]Message, spillSize),
+			wake: make(chan struct{}, 1),
+		}
+
+		go s.pump()
+	}
+
+	return s
+}
+
+func (s *sink) deliver(msg Message) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case BackpressureDropNewest:
+		if !s.send(msg, false) {
+			s.metrics.StoreDroppedMessage(s.policy.String())
+		}
+	case BackpressureDropOldest:
+		for !s.send(msg, false) {
+			if s.dropOldest() {
+				s.metrics.StoreDroppedMessage(s.policy.String())
+			}
+		}
+	case BackpressureSpill:
+		s.spillMessage(msg)
+	default:
+		s.send(msg, true)
+	}
+}
+
+// send returns false when the message was not queued because the channel is full or closed.
+func (s *sink) send(msg Message, block bool) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return true
+	}
+
+	if block {
+		select {
+		case s.ch <- msg:
+			return true
+		case <-s.done:
+			return true
+		}
+	}
+
+	select {
+	case s.ch <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *sink) dropOldest() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return false
+	}
+
+	select {
+	case <-s.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *sink) close() {
+	s.once.Do(func() { close(s.done) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+
+	close(s.ch)
+}
+
+type spillRing struct {
+	mu   sync.Mutex
+	buf  []Message
+	head int
+	size int
+	// busy is set while the pump holds a message, new messages are queued behind it to keep the order.
+	busy bool
+	wake chan struct{}
+}
+
+func (s *sink) spillMessage(msg Message) {
+	r := s.spill
+
+	r.mu.Lock()
+
+	if r.size == 0 && !r.busy && s.send(msg, false) {
+		r.mu.Unlock()
+
+		return
+	}
+
+	if r.size == len(r.buf) {
+		r.buf[r.head] = nil
+		r.head = (r.head + 1) % len(r.buf)
+		r.size--
+
+		s.metrics.StoreDroppedMessage(s.policy.String())
+	}
+
+	r.buf[(r.head+r.size)%len(r.buf)] = msg
+	r.size++
+
+	r.mu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *sink) pump() {
+	r := s.spill
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-r.wake:
+		}
+
+		for {
+			r.mu.Lock()
+
+			if r.size == 0 {
+				r.busy = false
+				r.mu.Unlock()
+
+				break
+			}
+
+			msg := r.buf[r.head]
+			r.buf[r.head] = nil
+			r.head = (r.head + 1) % len(r.buf)
+			r.size--
+			r.busy = true
+
+			r.mu.Unlock()
+
+			s.send(msg, true)
+
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+		}
+	}
+}
@@ -3,10 +3,14 @@ package amiclient
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Arten331/observability/logger"
@@ -14,94 +18,306 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	defaultLogoffTimeout = time.Second
+	defaultCloseTimeout  = 5 * time.Second
+	defaultWriteTimeout  = time.Second
+)
+
 var (
 	ErrConnectionFailed         = errors.New("TCP connection failed")
 	ErrClientDisabledBySettings = errors.New("ami client disabled by settings")
 	ErrAuthTimeOut              = errors.New("auth timeout")
+	ErrConnectionLost           = errors.New("connection lost")
+	ErrActionFailed             = errors.New("action failed")
+	ErrDuplicateActionID        = errors.New("duplicate ActionID")
+	ErrAuthFailed               = errors.New("authentication failed")
+	ErrChallengeFailed          = errors.New("auth challenge failed")
+	ErrInvalidBanner            = errors.New("invalid AMI banner")
+	ErrInvalidVersion           = errors.New("invalid AMI version")
+	ErrClientClosed             = errors.New("ami client closed")
+	ErrPingTimeout              = errors.New("ping timeout")
+	ErrMalformedHeader          = errors.New("malformed header")
 )
 
 type Settings struct {
-	ServiceName       string
-	Host              string
-	Port              int
-	Username          string
-	Password          string
-	ConnectionTimeout time.Duration
-	Disabled          bool
-	ReadTimeOut       time.Duration
+	ServiceName            string
+	Host                   string
+	Port                   int
+	Username               string
+	Password               string
+	AuthMethod             AuthMethod
+	ConnectionTimeout      time.Duration
+	Disabled               bool
+	ReadTimeOut            time.Duration
+	StrictParsing          bool
+	WriteTimeout           time.Duration
+	TLSConfig              *tls.Config
+	Reconnect              bool
+	ReconnectMinDelay      time.Duration
+	ReconnectMaxDelay      time.Duration
+	DisableMsgChan         bool // stops feeding MsgChan, see MsgChan
+	SubscriptionBufferSize int
+	Backpressure           BackpressurePolicy
+	SpillBufferSize        int
+	EventMask              string
+	Filters                []string
+	PingInterval           time.Duration
+	PingTimeout            time.Duration
+	Recorder               *Recorder
 }
 
 type Client struct {
 	settings   *Settings
+	connMu     sync.RWMutex
+	writeMu    sync.Mutex
 	conn       net.Conn
+	reader     *bufio.Reader
+	connGen    uint64 // incremented for every connection, a reader owns the generation it started with
+	ready      bool   // set once the connection is logged in, only the handshake writes before
+	readerGen  uint64 // generation read by the reader loop
+	version    Version
 	msgChan    chan Message
+	msgSink    *sink
 	errChan    chan error
 	stopReader chan interface{}
 	metrics    *Metrics
+
+	actionPrefix string
+	actionSeq    uint64
+	pendingMu    sync.Mutex
+	pending      map[string]*pendingAction
+
+	stateMu        sync.Mutex
+	state          ConnectionState
+	stateListeners map[uint64]func(ConnectionState)
+	listenerSeq    uint64
+
+	filtersMu sync.Mutex
+	eventMask string
+	filters   []string
+
+	subsMu sync.Mutex
+	subs   atomic.Value
+	subSeq uint64
+	closed bool
+
+	closeMu   sync.Mutex
+	closing   bool
+	closeOnce sync.Once
+	closeErr  error
+	readers   sync.WaitGroup
 }
 
 func New(cfg *Settings) *Client {
 	c := &Client{
 		settings: cfg,
 		metrics:  newMetrics(cfg.ServiceName),
+		pending:  make(map[string]*pendingAction),
+
+		stateListeners: make(map[uint64]func(ConnectionState)),
+
+		eventMask: cfg.EventMask,
+		filters:   append([]string(nil), cfg.Filters...),
 	}
 
+	c.actionPrefix = strconv.FormatInt(time.Now().UnixNano(), 36)
+
 	c.msgChan = make(chan Message, 100)
+	c.msgSink = c.newSink(c.msgChan)
 	c.errChan = make(chan error, 1)
 	c.stopReader = make(chan interface{}, 1)
 
 	return c
 }
 
+// Connect opens the connection and logs in. With runReader the messages are read in background,
+// Settings.Reconnect additionally keeps the connection alive until ctx is done or Close is called.
 func (c *Client) Connect(ctx context.Context, runReader bool) error {
-	var err error
-
-	if c.conn != nil {
-		_ = c.conn.Close()
-	}
-
 	if c.Disabled() {
 		return ErrClientDisabledBySettings
 	}
 
-	err = c.openConnection(ctx)
-	if err != nil {
-		return err
+	if c.isClosing() {
+		return ErrClientClosed
 	}
 
-	err = c.auth(ctx)
+	err := c.connect(ctx)
 	if err != nil {
 		return err
 	}
 
-	logger.L().Info("Client " + c.conn.LocalAddr().String() + " connected to " + c.conn.RemoteAddr().String())
-
 	if runReader {
-		go c.runReader(ctx)
+		c.closeMu.Lock()
+		if c.closing {
+			c.closeMu.Unlock()
+
+			return ErrClientClosed
+		}
+
+		c.readers.Add(1)
+		c.closeMu.Unlock()
+
+		go func() {
+			defer c.readers.Done()
+
+			if c.settings.Reconnect {
+				c.supervise(ctx)
+			} else {
+				c.runReader(ctx)
+			}
+		}()
+
 		<-time.After(time.Millisecond * 50)
 	}
 
 	return nil
 }
 
-func (c *Client) Disconnect() {
+func (c *Client) connect(ctx context.Context) error {
+	var err error
+
+	// the reader of the old connection sees the new generation and leaves the state alone
+	c.connMu.Lock()
+	old := c.conn
+	c.connGen++
+	c.ready = false
+	c.connMu.Unlock()
+
+	if old != nil {
+		_ = old.Close()
+		c.failPending()
+	}
+
+	c.setState(StateConnecting)
+
 	defer func() {
-		err := recover()
 		if err != nil {
-			logger.L().Info("ignore panic on disconnect AMI client", zap.Any("recover", err))
-
-			return
+			c.setState(StateDisconnected)
 		}
 	}()
 
-	if c.conn != nil {
-		_ = c.conn.Close()
-		logger.L().Info("AMI client disconected", zap.String("address", c.conn.RemoteAddr().String()))
+	err = c.openConnection(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.readBanner(ctx)
+	if err == nil {
+		err = c.auth(ctx)
+	}
+
+	if err == nil {
+		err = c.applyFilters(ctx)
+	}
+
+	if err != nil {
+		_ = c.connection().Close()
+
+		return err
+	}
+
+	c.connMu.Lock()
+	conn := c.conn
+	c.ready = true
+	c.connMu.Unlock()
+
+	logger.L().Info("Client "+conn.LocalAddr().String()+" connected to "+conn.RemoteAddr().String(),
+		zap.Stringer("ami_version", c.ProtocolVersion()))
+
+	c.setState(StateConnected)
+
+	return nil
+}
+
+// Close sends Logoff, stops the reader and waits for it to exit. Waiting Do calls get
+// ErrClientClosed, MsgChan, ErrChan and the subscription channels are closed afterwards.
+// Close is safe to call more than once and concurrently, every call returns the first result.
+func (c *Client) Close(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.close(ctx)
+	})
+
+	return c.closeErr
+}
+
+func (c *Client) close(ctx context.Context) error {
+	c.closeMu.Lock()
+	c.closing = true
+	c.closeMu.Unlock()
+
+	if c.State() == StateConnected {
+		c.logoff(ctx)
 	}
 
 	close(c.stopReader)
-	close(c.msgChan)
-	close(c.errChan)
+
+	conn := c.connection()
+	if conn != nil {
+		_ = conn.Close()
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		c.readers.Wait()
+
+		close(c.errChan)
+		close(done)
+	}()
+
+	var err error
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	c.failPending()
+	c.setState(StateDisconnected)
+	c.closeSubscriptions()
+	c.msgSink.close()
+
+	if conn != nil {
+		logger.L().Info("AMI client disconnected", zap.String("address", conn.RemoteAddr().String()))
+	}
+
+	return err
+}
+
+// logoff is best effort, the connection is closed anyway. Without the reader loop nobody
+// reads the response, Logoff is only sent then.
+func (c *Client) logoff(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, defaultLogoffTimeout)
+	defer cancel()
+
+	var err error
+
+	if c.reading() {
+		_, err = c.Do(ctx, NewAction("Logoff"))
+	} else {
+		err = c.SendCommandContext(ctx, NewAction("Logoff"))
+	}
+
+	if err != nil {
+		logger.L().Debug("AMI logoff failed", zap.Error(err))
+	}
+}
+
+// Disconnect closes the client, see Close.
+func (c *Client) Disconnect() {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+
+	_ = c.Close(ctx)
+}
+
+func (c *Client) isClosing() bool {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+
+	return c.closing
 }
 
 func (c *Client) openConnection(ctx context.Context) error {
@@ -110,7 +326,13 @@ func (c *Client) openConnection(ctx context.Context) error {
 
 	c.metrics.StoreConnectionCount()
 
-	dialer := net.Dialer{}
+	var dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	} = &net.Dialer{}
+
+	if c.settings.TLSConfig != nil {
+		dialer = &tls.Dialer{Config: c.tlsConfig()}
+	}
 
 	conn, err := dialer.DialContext(ctx, "tcp",
 		net.JoinHostPort(c.settings.Host, strconv.Itoa(c.settings.Port)))
@@ -120,91 +342,226 @@ func (c *Client) openConnection(ctx context.Context) error {
 			zap.Error(err),
 		)
 
-		return ErrConnectionFailed
+		return fmt.Errorf("%w: %w", ErrConnectionFailed, err)
 	}
 
 	logger.L().Info(fmt.Sprintf("open connection %s", conn.RemoteAddr()))
 
+	c.connMu.Lock()
 	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.connMu.Unlock()
 
 	return nil
 }
 
-func (c *Client) auth(ctx context.Context) error {
-	authCommand := Action{
-		"Action":   "Login",
-		"Username": c.settings.Username,
-		"Secret":   c.settings.Password,
+func (c *Client) tlsConfig() *tls.Config {
+	cfg := c.settings.TLSConfig.Clone()
+
+	if cfg.ServerName == "" {
+		cfg.ServerName = c.settings.Host
 	}
 
-	err := c.SendCommand(authCommand)
-	if err != nil {
+	return cfg
+}
+
+func (c *Client) connection() net.Conn {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+
+	return c.conn
+}
+
+// connectionReader is the buffered reader of the connection, used by the handshake before the reader loop starts.
+func (c *Client) connectionReader() *bufio.Reader {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+
+	return c.reader
+}
+
+// reading reports whether the reader loop reads the current connection.
+func (c *Client) reading() bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+
+	return c.readerGen == c.connGen
+}
+
+// currentConnection reports whether gen is still the generation of the client connection.
+func (c *Client) currentConnection(gen uint64) bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+
+	return c.connGen == gen
+}
+
+// SendCommand writes the action bounded by Settings.WriteTimeout, see SendCommandContext.
+func (c *Client) SendCommand(command Action) error {
+	return c.SendCommandContext(context.Background(), command)
+}
+
+// SendCommandContext writes the action, concurrent calls are serialized so actions never interleave.
+// The write is bounded by the ctx deadline (Settings.WriteTimeout when ctx has none) and aborted
+// when ctx is canceled. A partially written action corrupts the stream, so the connection is closed.
+// ErrConnectionLost is returned until the connection is logged in, also during a reconnect.
+func (c *Client) SendCommandContext(ctx context.Context, command Action) error {
+	return c.send(ctx, command, false)
+}
+
+// send writes the action, handshake writes to a connection that is not logged in yet.
+func (c *Client) send(ctx context.Context, command Action, handshake bool) error {
+	commandBytes := command.Serialize()
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.connMu.RLock()
+	conn, ready := c.conn, c.ready
+	c.connMu.RUnlock()
+
+	if conn == nil || !ready && !handshake {
+		return ErrConnectionLost
+	}
+
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	reader := bufio.NewReader(c.conn)
-	msgChan := make(chan Message, 100)
-	errChan := make(chan error, 1)
+	_ = conn.SetWriteDeadline(c.writeDeadline(ctx))
+	defer func() { _ = conn.SetWriteDeadline(time.Time{}) }()
 
-	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
-	defer cancel()
+	defer watchWrite(ctx, conn)()
 
-	for {
-		select {
-		case <-ctx.Done():
-			errChan <- ErrAuthTimeOut
+	written := 0
 
-			return ErrAuthTimeOut
-		case err = <-errChan:
-			return err
-		case msg := <-msgChan:
-			if msg["Response"] != "Success" && msg["Message"] != "Authentication accepted" {
-				logger.S().Info("ami auth: receive message", msg)
+	for written < len(commandBytes) {
+		n, err := conn.Write(commandBytes[written:])
+		written += n
 
-				if msg["Response"] == "Error" {
-					return fmt.Errorf("authenfication failed: %s", msg["Message"])
-				}
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
 
-				continue
-			}
+		if err == nil {
+			continue
+		}
 
-			logger.L().Info("Authentication accepted, User: %s", zap.String("username", c.settings.Username))
-			cancel()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
 
-			return nil
-		default:
-			msg, errRead := ReadMessage(reader)
-			if errRead != nil {
-				errChan <- errRead
-			}
+		if written > 0 {
+			_ = conn.Close()
 
-			if len(msg) == 0 {
-				continue
-			}
+			return fmt.Errorf("%w: command partially sent, %d of %d bytes written: %w",
+				ErrConnectionLost, written, len(commandBytes), err)
+		}
+
+		return err
+	}
+
+	c.metrics.StoreSentMessage()
+	c.settings.Recorder.Record(DirectionOut, command)
+
+	return nil
+}
+
+func (c *Client) writeDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+
+	timeout := c.settings.WriteTimeout
+	if timeout <= 0 {
+		timeout = defaultWriteTimeout
+	}
 
-			msgChan <- msg
+	return time.Now().Add(timeout)
+}
+
+// watchWrite interrupts a blocked write when ctx is canceled, the returned function
+// stops watching and returns after the watcher is gone so it cannot touch the next write.
+func watchWrite(ctx context.Context, conn net.Conn) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		select {
+		case <-ctx.Done():
+			_ = conn.SetWriteDeadline(time.Unix(1, 0))
+		case <-stop:
 		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
 	}
 }
 
-func (c *Client) SendCommand(command Action) error {
-	commandBytes := command.Serialize()
-	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
-	n, err := c.conn.Write(commandBytes)
+// Do sends the action and waits for the response carrying the same ActionID.
+// ActionID is generated when the action has none. Response: Error is
+// returned together with an ErrActionFailed error.
+func (c *Client) Do(ctx context.Context, action Action) (Message, error) {
+	action, id := c.withActionID(action)
 
-	if n != len(commandBytes) {
-		return fmt.Errorf("command not send, %d bytes writed", n)
+	p, err := c.registerPending(id, false, nil)
+	if err != nil {
+		return nil, err
 	}
+	defer c.unregisterPending(id)
 
-	c.metrics.StoreSentMessage()
+	err = c.SendCommandContext(ctx, action)
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	msg, err := p.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, responseError(msg)
+}
+
+// NewActionID returns a unique ActionID, an action sent with it is recognized by the messages it causes.
+func (c *Client) NewActionID() string {
+	return c.actionPrefix + "-" + strconv.FormatUint(atomic.AddUint64(&c.actionSeq, 1), 10)
+}
+
+func (c *Client) withActionID(action Action) (Action, string) {
+	cmd := action.Clone()
+
+	id := cmd.Get("ActionID")
+	if id == "" {
+		id = c.NewActionID()
+		cmd.Set("ActionID", id)
+	}
+
+	return cmd, id
+}
+
+func responseError(msg Message) error {
+	if msg.Get("Response") != "Error" {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrActionFailed, msg.Get("Message"))
 }
 
 func (c *Client) GetMetrics() []prometheus.Collector {
 	return c.metrics.getMetrics()
 }
 
+// MsgChan returns the channel of the event stream messages not consumed by Do. It is fed unless
+// Settings.DisableMsgChan is set, an unread MsgChan blocks the reader with BackpressureBlock,
+// so set DisableMsgChan when only subscriptions consume the events.
 func (c *Client) MsgChan() chan Message {
 	return c.msgChan
 }
@@ -214,7 +571,7 @@ func (c *Client) ErrChan() chan error {
 }
 
 func (c *Client) Addr() net.Addr {
-	return c.conn.RemoteAddr()
+	return c.connection().RemoteAddr()
 }
 
 func (c *Client) Disabled() bool {
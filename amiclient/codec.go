@@ -0,0 +1,299 @@
+package amiclient
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const codecTag = "ami"
+
+var (
+	ErrInvalidTarget  = errors.New("target must be a non-nil pointer to struct")
+	ErrInvalidSource  = errors.New("source must be a struct")
+	ErrRequiredHeader = errors.New("required header is empty")
+	ErrInvalidHeader  = errors.New("invalid header value")
+)
+
+// Marshal builds an action from struct fields using the same naming rules as Unmarshal.
+// Tag options: omitempty skips zero values, required fails with ErrRequiredHeader on zero values.
+// []string fields produce a repeated header, map[string]string fields produce repeated
+// "Key: name=value" headers sorted by name (Variable on Originate).
+func Marshal(name string, v interface{}) (Action, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil, ErrInvalidSource
+	}
+
+	action := NewAction(name)
+
+	err := marshalStruct(&action, rv, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return action, nil
+}
+
+func marshalStruct(action *Action, rv reflect.Value, prefix string) error {
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, skip := fieldName(field)
+		if skip {
+			continue
+		}
+
+		fv := rv.Field(i)
+		key := prefix + name
+		omitEmpty, required := tagOptions(field)
+
+		if fv.IsZero() {
+			if required {
+				return fmt.Errorf("%w: %s", ErrRequiredHeader, key)
+			}
+
+			if omitEmpty {
+				continue
+			}
+		}
+
+		//nolint:exhaustive // scalar kinds are handled by formatValue
+		switch fv.Kind() {
+		case reflect.Struct:
+			nested := prefix
+			if !field.Anonymous || field.Tag.Get(codecTag) != "" {
+				nested = key
+			}
+
+			if err := marshalStruct(action, fv, nested); err != nil {
+				return err
+			}
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("header %s: unsupported field type %s", key, fv.Type())
+			}
+
+			for j := 0; j < fv.Len(); j++ {
+				action.Add(key, fv.Index(j).String())
+			}
+		case reflect.Map:
+			if fv.Type().Key().Kind() != reflect.String || fv.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("header %s: unsupported field type %s", key, fv.Type())
+			}
+
+			names := make([]string, 0, fv.Len())
+			for _, k := range fv.MapKeys() {
+				names = append(names, k.String())
+			}
+
+			sort.Strings(names)
+
+			for _, n := range names {
+				action.Add(key, n+"="+fv.MapIndex(reflect.ValueOf(n).Convert(fv.Type().Key())).String())
+			}
+		default:
+			value, err := formatValue(fv)
+			if err != nil {
+				return fmt.Errorf("header %s: %w", key, err)
+			}
+
+			action.Add(key, value)
+		}
+	}
+
+	return nil
+}
+
+func tagOptions(field reflect.StructField) (omitEmpty, required bool) {
+	_, opts, _ := strings.Cut(field.Tag.Get(codecTag), ",")
+
+	for opts != "" {
+		var opt string
+
+		opt, opts, _ = strings.Cut(opts, ",")
+
+		switch opt {
+		case "omitempty":
+			omitEmpty = true
+		case "required":
+			required = true
+		}
+	}
+
+	return omitEmpty, required
+}
+
+func formatValue(fv reflect.Value) (string, error) {
+	//nolint:exhaustive // unsupported kinds are reported below
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, fv.Type().Bits()), nil
+	default:
+		return "", fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+}
+
+// Unmarshal fills struct fields from message headers. The header name is the field name or the
+// `ami:"Name"` tag, `ami:"-"` skips the field. Embedded structs are flattened, a named struct
+// field reads headers prefixed with its name (Dest reads DestChannel, DestUniqueid, ...).
+// String, bool, integer, float and []string (all values of a repeated header) fields are supported.
+// A header that fails to parse leaves its field unchanged and the other fields are still filled,
+// the errors of such headers wrap ErrInvalidHeader and are returned joined.
+func Unmarshal(msg Message, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidTarget
+	}
+
+	return errors.Join(unmarshalStruct(msg, rv.Elem(), "", nil)...)
+}
+
+func unmarshalStruct(msg Message, rv reflect.Value, prefix string, errs []error) []error {
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, skip := fieldName(field)
+		if skip {
+			continue
+		}
+
+		fv := rv.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			nested := prefix
+			if !field.Anonymous || field.Tag.Get(codecTag) != "" {
+				nested = prefix + name
+			}
+
+			errs = unmarshalStruct(msg, fv, nested, errs)
+
+			continue
+		}
+
+		key := prefix + name
+
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String {
+			values := msg.GetAll(key)
+			if values != nil {
+				fv.Set(reflect.ValueOf(values).Convert(field.Type))
+			}
+
+			continue
+		}
+
+		value, ok := msg.Lookup(key)
+		if !ok {
+			continue
+		}
+
+		if err := setValue(fv, value); err != nil {
+			errs = append(errs, fmt.Errorf("%w %s: %w", ErrInvalidHeader, key, err))
+		}
+	}
+
+	return errs
+}
+
+func fieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get(codecTag)
+	if tag == "-" {
+		return "", true
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+
+	return name, false
+}
+
+func setValue(fv reflect.Value, value string) error {
+	//nolint:exhaustive // unsupported kinds are reported below
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value == "" {
+			return nil
+		}
+
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value == "" {
+			return nil
+		}
+
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		if value == "" {
+			return nil
+		}
+
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+
+	return nil
+}
+
+// ParseBool accepts the boolean spellings used by AMI: Yes/No, true/false, on/off, 1/0.
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "true", "on", "1", "y", "t":
+		return true, nil
+	case "no", "false", "off", "0", "n", "f", "":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid boolean %q", value)
+	}
+}
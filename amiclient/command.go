@@ -0,0 +1,28 @@
+package amiclient
+
+import (
+	"context"
+)
+
+// CommandResult is the output of a CLI command run through the Command action.
+type CommandResult struct {
+	Response Message
+	Output   []string
+}
+
+// Command runs a CLI command ("core show channels") and returns its output lines. Both the
+// "Response: Follows" body of Asterisk 13 and the Output headers of newer versions are supported.
+func (c *Client) Command(ctx context.Context, command string) (*CommandResult, error) {
+	action := NewAction("Command")
+	action.Add("Command", command)
+
+	msg, err := c.Do(ctx, action)
+	if msg == nil {
+		return nil, err
+	}
+
+	return &CommandResult{
+		Response: msg,
+		Output:   msg.GetAll("Output"),
+	}, err
+}
@@ -0,0 +1,259 @@
+// Package endpoint keeps the registration and reachability of the chan_sip peers and
+// PJSIP endpoints of an Asterisk server up to date from the AMI event stream.
+package endpoint
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient/events"
+)
+
+const (
+	TechSIP   = "SIP"
+	TechPJSIP = "PJSIP"
+)
+
+// State is the qualify result of an endpoint.
+type State int
+
+const (
+	StateUnknown State = iota
+	StateReachable
+	StateLagged
+	StateUnreachable
+)
+
+func (s State) String() string {
+	switch s {
+	case StateReachable:
+		return "Reachable"
+	case StateLagged:
+		return "Lagged"
+	case StateUnreachable:
+		return "Unreachable"
+	default:
+		return "Unknown"
+	}
+}
+
+// Contact is a registered or static contact of a PJSIP endpoint.
+type Contact struct {
+	URI       string
+	AOR       string
+	Address   string
+	UserAgent string
+	State     State
+	Latency   time.Duration
+}
+
+// Endpoint is the last known state of a chan_sip peer or a PJSIP endpoint.
+type Endpoint struct {
+	// Name is the device name, for example SIP/trunk or PJSIP/101.
+	Name        string
+	Technology  string
+	Registered  bool
+	State       State
+	Address     string
+	Latency     time.Duration
+	DeviceState string
+	// Contacts are the PJSIP contacts ordered by URI.
+	Contacts []Contact
+	// UnreachableSince is the time the endpoint became unreachable, zero otherwise.
+	UnreachableSince time.Time
+	Updated          time.Time
+}
+
+// Contact returns the contact with the URI.
+func (e Endpoint) Contact(uri string) (Contact, bool) {
+	for _, c := range e.Contacts {
+		if c.URI == uri {
+			return c, true
+		}
+	}
+
+	return Contact{}, false
+}
+
+// Change describes a registry change. Event is the event caused it, nil for the bootstrap.
+// Previous is the state before the change.
+type Change struct {
+	Endpoint Endpoint
+	Previous State
+	Removed  bool
+	Event    events.Event
+}
+
+type endpoint struct {
+	info     Endpoint
+	contacts map[string]*Contact
+}
+
+func newEndpoint(name string) *endpoint {
+	tech, _, _ := strings.Cut(name, "/")
+
+	return &endpoint{
+		info:     Endpoint{Name: name, Technology: tech},
+		contacts: make(map[string]*Contact),
+	}
+}
+
+func (e *endpoint) snapshot() Endpoint {
+	res := e.info
+
+	if len(e.contacts) > 0 {
+		res.Contacts = make([]Contact, 0, len(e.contacts))
+		for _, c := range e.contacts {
+			res.Contacts = append(res.Contacts, *c)
+		}
+
+		sort.Slice(res.Contacts, func(i, j int) bool { return res.Contacts[i].URI < res.Contacts[j].URI })
+	}
+
+	return res
+}
+
+func (e *endpoint) clone() *endpoint {
+	res := &endpoint{info: e.info, contacts: make(map[string]*Contact, len(e.contacts))}
+
+	for uri, c := range e.contacts {
+		contact := *c
+		res.contacts[uri] = &contact
+	}
+
+	return res
+}
+
+// setState updates the state keeping the time the endpoint became unreachable.
+func (e *endpoint) setState(state State, now time.Time) {
+	switch {
+	case state != StateUnreachable:
+		e.info.UnreachableSince = time.Time{}
+	case e.info.State != StateUnreachable || e.info.UnreachableSince.IsZero():
+		e.info.UnreachableSince = now
+	}
+
+	e.info.State = state
+}
+
+// aggregate derives the PJSIP endpoint state from its contacts: reachable when any contact is,
+// unreachable without contacts or when all of them are unreachable.
+func (e *endpoint) aggregate(now time.Time) {
+	e.info.Registered = len(e.contacts) > 0
+
+	state, latency, address := StateUnreachable, time.Duration(0), ""
+
+	for _, c := range e.sortedContacts() {
+		if address == "" {
+			address = c.Address
+		}
+
+		switch {
+		case c.State == StateReachable && (state != StateReachable || c.Latency < latency):
+			state, latency, address = StateReachable, c.Latency, c.Address
+		case c.State == StateUnknown && state == StateUnreachable:
+			state = StateUnknown
+		}
+	}
+
+	e.info.Address, e.info.Latency = address, latency
+	e.setState(state, now)
+}
+
+// changed reports whether the endpoint differs from its previous state, the latencies
+// vary with every qualify and are not compared.
+func changed(previous, current *endpoint) bool {
+	a, b := previous.info, current.info
+	if a.Registered != b.Registered || a.State != b.State || a.Address != b.Address ||
+		a.DeviceState != b.DeviceState || !a.UnreachableSince.Equal(b.UnreachableSince) ||
+		len(previous.contacts) != len(current.contacts) {
+		return true
+	}
+
+	for uri, c := range previous.contacts {
+		l, ok := current.contacts[uri]
+		if !ok || c.AOR != l.AOR || c.Address != l.Address || c.UserAgent != l.UserAgent || c.State != l.State {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *endpoint) sortedContacts() []*Contact {
+	res := make([]*Contact, 0, len(e.contacts))
+	for _, c := range e.contacts {
+		res = append(res, c)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].URI < res[j].URI })
+
+	return res
+}
+
+// parseSIPStatus parses the SIPpeers status like "OK (5 ms)" or "LAGGED (2100 ms)".
+func parseSIPStatus(status string) (State, time.Duration) {
+	word, rest, _ := strings.Cut(status, " ")
+
+	var latency time.Duration
+
+	if ms, err := strconv.Atoi(strings.TrimSuffix(strings.Trim(rest, "()"), " ms")); err == nil {
+		latency = time.Duration(ms) * time.Millisecond
+	}
+
+	switch strings.ToUpper(word) {
+	case "OK":
+		return StateReachable, latency
+	case "LAGGED":
+		return StateLagged, latency
+	case "UNREACHABLE":
+		return StateUnreachable, 0
+	default:
+		return StateUnknown, 0
+	}
+}
+
+// parseContactStatus parses the PJSIP contact status of ContactStatus and PJSIPShowContacts.
+func parseContactStatus(status string) State {
+	switch status {
+	case "Reachable":
+		return StateReachable
+	case "Unreachable", "Unavailable":
+		return StateUnreachable
+	default:
+		return StateUnknown
+	}
+}
+
+// peerAddress joins the PeerStatus address and port, chan_sip puts both in Address.
+func peerAddress(address string, port int) string {
+	if address == "" || port == 0 || strings.Contains(address, ":") {
+		return address
+	}
+
+	return address + ":" + strconv.Itoa(port)
+}
+
+// sipAddress reports the peer address, unregistered dynamic peers have "-none-" or "(null)".
+func sipAddress(ip string, port int) string {
+	if ip == "" || ip == "-none-" || ip == "(null)" {
+		return ""
+	}
+
+	if port == 0 {
+		return ip
+	}
+
+	return ip + ":" + strconv.Itoa(port)
+}
+
+func usec(value string) time.Duration {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return time.Duration(n) * time.Microsecond
+}
@@ -0,0 +1,724 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arten331/observability/logger"
+	"go.uber.org/zap"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/events"
+)
+
+const bootstrapTimeout = 30 * time.Second
+
+var ErrBootstrapFailed = errors.New("no endpoint list loaded")
+
+//nolint:gochecknoglobals // subscription filter
+var endpointEvents = []string{"PeerStatus", "ContactStatus", "DeviceStateChange"}
+
+// Registry is a concurrency safe registry of the SIP peers and PJSIP endpoints keyed by device
+// name. It follows the registration and qualify events of the client and reloads the registry
+// with SIPpeers, PJSIPShowEndpoints and PJSIPShowContacts on every (re)connect.
+type Registry struct {
+	client *amiclient.Client
+
+	mu        sync.RWMutex
+	endpoints map[string]*endpoint
+	// aors maps the PJSIP AORs to the endpoint names, ContactStatus may come without EndpointName.
+	aors     map[string]string
+	alerts   map[uint64]*alert
+	alertSeq uint64
+
+	listenersMu sync.Mutex
+	listeners   map[uint64]func(Change)
+	listenerSeq uint64
+
+	// merge follows the lists of the last bootstrap until they are done, see handleList.
+	merge  *amiclient.ListMerge
+	listed listed
+
+	sub          *amiclient.Subscription
+	unregister   func()
+	cancel       context.CancelFunc
+	bootstrapped chan struct{}
+	once         sync.Once
+}
+
+// listed is what the running bootstrap loaded so far.
+type listed struct {
+	// peers, endpoints and contacts are the ActionIDs of SIPpeers, PJSIPShowEndpoints and PJSIPShowContacts.
+	peers, endpoints, contacts string
+	aors                       map[string]string
+	// previous keeps the endpoints changed by the lists as they were before, nil for the new ones.
+	previous map[string]*endpoint
+}
+
+type alert struct {
+	after  time.Duration
+	fn     func(Endpoint)
+	timers map[string]alertTimer
+}
+
+// alertTimer fires once for the unreachable period started at since.
+type alertTimer struct {
+	since time.Time
+	timer *time.Timer
+}
+
+func NewRegistry(client *amiclient.Client) *Registry {
+	return &Registry{
+		client:       client,
+		endpoints:    make(map[string]*endpoint),
+		aors:         make(map[string]string),
+		alerts:       make(map[uint64]*alert),
+		listeners:    make(map[uint64]func(Change)),
+		bootstrapped: make(chan struct{}),
+	}
+}
+
+// Start subscribes to the endpoint events and bootstraps the registry when the client
+// is connected, further bootstraps run after every reconnect until Stop or ctx is done.
+func (r *Registry) Start(ctx context.Context) error {
+	ctx, r.cancel = context.WithCancel(ctx)
+
+	sub, err := r.client.SubscribeFunc(amiclient.Filter{Events: endpointEvents}, r.handleMessage)
+	if err != nil {
+		r.cancel()
+
+		return err
+	}
+
+	r.sub = sub
+
+	r.unregister = r.client.OnStateChange(func(state amiclient.ConnectionState) {
+		if state == amiclient.StateConnected {
+			go r.bootstrap(ctx)
+		}
+	})
+
+	if r.client.State() == amiclient.StateConnected {
+		go r.bootstrap(ctx)
+	}
+
+	return nil
+}
+
+// Stop unsubscribes from the events and stops the pending alerts.
+func (r *Registry) Stop() {
+	if r.unregister != nil {
+		r.unregister()
+	}
+
+	if r.sub != nil {
+		r.sub.Unsubscribe()
+	}
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, a := range r.alerts {
+		a.stop()
+	}
+}
+
+// Bootstrapped is closed after the first successful bootstrap.
+func (r *Registry) Bootstrapped() <-chan struct{} {
+	return r.bootstrapped
+}
+
+// OnChange registers a listener called on every registry change in the order of the events,
+// the returned function removes it. Listeners are called synchronously and must not block.
+func (r *Registry) OnChange(fn func(Change)) func() {
+	r.listenersMu.Lock()
+	defer r.listenersMu.Unlock()
+
+	r.listenerSeq++
+	id := r.listenerSeq
+	r.listeners[id] = fn
+
+	return func() {
+		r.listenersMu.Lock()
+		defer r.listenersMu.Unlock()
+
+		delete(r.listeners, id)
+	}
+}
+
+// OnUnreachable registers an alert called once when an endpoint stays unreachable for the
+// duration, again after it recovers and fails once more. Endpoints already unreachable count
+// from the time they became so. The returned function removes the alert.
+func (r *Registry) OnUnreachable(after time.Duration, fn func(Endpoint)) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.alertSeq++
+	id := r.alertSeq
+	a := &alert{after: after, fn: fn, timers: make(map[string]alertTimer)}
+	r.alerts[id] = a
+
+	for _, ep := range r.endpoints {
+		r.scheduleAlert(id, a, ep)
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		a.stop()
+		delete(r.alerts, id)
+	}
+}
+
+func (r *Registry) Endpoint(name string) (Endpoint, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ep, ok := r.endpoints[name]
+	if !ok {
+		return Endpoint{}, false
+	}
+
+	return ep.snapshot(), true
+}
+
+// Endpoints returns the endpoints ordered by name.
+func (r *Registry) Endpoints() []Endpoint {
+	r.mu.RLock()
+
+	res := make([]Endpoint, 0, len(r.endpoints))
+	for _, ep := range r.endpoints {
+		res = append(res, ep.snapshot())
+	}
+
+	r.mu.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+	return res
+}
+
+// Unreachable returns the endpoints unreachable for at least the duration.
+func (r *Registry) Unreachable(after time.Duration) []Endpoint {
+	res := make([]Endpoint, 0)
+
+	for _, ep := range r.Endpoints() {
+		if ep.State == StateUnreachable && time.Since(ep.UnreachableSince) >= after {
+			res = append(res, ep)
+		}
+	}
+
+	return res
+}
+
+func (r *Registry) handleMessage(msg amiclient.Message) {
+	if r.handleList(msg) {
+		return
+	}
+
+	e, err := events.Decode(msg)
+	if e == nil {
+		logger.L().Debug("endpoint registry skipped event", zap.Error(err))
+
+		return
+	}
+
+	if err != nil {
+		logger.L().Debug("endpoint registry event decoded partially", zap.Error(err))
+	}
+
+	r.Apply(e)
+}
+
+// Apply updates the registry with the event, unrelated events are ignored.
+func (r *Registry) Apply(e events.Event) {
+	now := time.Now()
+
+	r.mu.Lock()
+
+	ep, previous := r.apply(e, now)
+	if ep == nil {
+		r.mu.Unlock()
+
+		return
+	}
+
+	ep.info.Updated = now
+	change := Change{Endpoint: ep.snapshot(), Previous: previous, Event: e}
+
+	// the lists report what changed after the event only
+	if _, ok := r.listed.previous[ep.info.Name]; ok {
+		r.listed.previous[ep.info.Name] = ep.clone()
+	}
+
+	r.schedule(ep)
+	r.mu.Unlock()
+
+	r.notify(change)
+}
+
+// apply returns the changed endpoint and its previous state, must be called under mu.
+func (r *Registry) apply(e events.Event, now time.Time) (*endpoint, State) {
+	switch e := e.(type) {
+	case *events.PeerStatus:
+		// the PJSIP state is derived from the contacts
+		if e.Peer == "" || e.ChannelType == TechPJSIP {
+			return nil, StateUnknown
+		}
+
+		ep := r.endpoint(e.Peer)
+		previous := ep.info.State
+
+		r.seen(ep.info.Name)
+
+		peerStatus(ep, e, now)
+
+		return ep, previous
+	case *events.ContactStatus:
+		name := e.EndpointName
+		if name == "" {
+			name = r.aors[e.AOR]
+		}
+
+		if name == "" || e.URI == "" {
+			return nil, StateUnknown
+		}
+
+		ep := r.endpoint(TechPJSIP + "/" + name)
+		previous := ep.info.State
+
+		r.seen(ep.info.Name)
+		r.seen(ep.info.Name, e.URI)
+
+		contactStatus(ep, e)
+		ep.aggregate(now)
+
+		return ep, previous
+	case *events.DeviceStateChange:
+		ep, ok := r.endpoints[e.Device]
+		if !ok {
+			return nil, StateUnknown
+		}
+
+		ep.info.DeviceState = e.State
+		r.seen(ep.info.Name)
+
+		return ep, ep.info.State
+	}
+
+	return nil, StateUnknown
+}
+
+// endpoint returns the endpoint creating it when needed, must be called under mu.
+func (r *Registry) endpoint(name string) *endpoint {
+	ep, ok := r.endpoints[name]
+	if !ok {
+		ep = newEndpoint(name)
+		r.endpoints[name] = ep
+	}
+
+	return ep
+}
+
+// seen keeps the endpoint, or its contact, out of the stale ones of the running bootstrap.
+// Must be called under mu.
+func (r *Registry) seen(keys ...string) {
+	if r.merge != nil {
+		r.merge.Seen(strings.Join(keys, "\x00"))
+	}
+}
+
+func (r *Registry) stale(keys ...string) bool {
+	return r.merge.Stale(strings.Join(keys, "\x00"))
+}
+
+func peerStatus(ep *endpoint, e *events.PeerStatus, now time.Time) {
+	switch e.PeerStatus {
+	case "Registered":
+		ep.info.Registered = true
+
+		if address := peerAddress(e.Address, e.Port); address != "" {
+			ep.info.Address = address
+		}
+
+		// the qualify result follows, until then the state of the old registration is unknown
+		if ep.info.State == StateUnreachable {
+			ep.setState(StateUnknown, now)
+		}
+	case "Unregistered":
+		ep.info.Registered, ep.info.Address, ep.info.Latency = false, "", 0
+		ep.setState(StateUnreachable, now)
+	case "Rejected":
+		ep.info.Registered = false
+	case "Reachable":
+		ep.info.Latency = time.Duration(e.Time) * time.Millisecond
+		ep.setState(StateReachable, now)
+	case "Lagged":
+		ep.info.Latency = time.Duration(e.Time) * time.Millisecond
+		ep.setState(StateLagged, now)
+	case "Unreachable":
+		ep.info.Latency = 0
+		ep.setState(StateUnreachable, now)
+	}
+}
+
+func contactStatus(ep *endpoint, e *events.ContactStatus) {
+	if e.ContactStatus == "Removed" {
+		delete(ep.contacts, e.URI)
+
+		return
+	}
+
+	c, ok := ep.contacts[e.URI]
+	if !ok {
+		c = &Contact{URI: e.URI}
+		ep.contacts[e.URI] = c
+	}
+
+	c.AOR = e.AOR
+
+	if e.ViaAddress != "" {
+		c.Address = e.ViaAddress
+	}
+
+	if e.UserAgent != "" {
+		c.UserAgent = e.UserAgent
+	}
+
+	// Created and Updated report the registration, the qualify result comes separately
+	if e.ContactStatus == "Created" || e.ContactStatus == "Updated" {
+		return
+	}
+
+	c.State = parseContactStatus(e.ContactStatus)
+	c.Latency = 0
+
+	if c.State == StateReachable {
+		c.Latency = time.Duration(e.RoundtripUsec) * time.Microsecond
+	}
+}
+
+func (r *Registry) notify(change Change) {
+	r.listenersMu.Lock()
+
+	listeners := make([]func(Change), 0, len(r.listeners))
+	for _, fn := range r.listeners {
+		listeners = append(listeners, fn)
+	}
+
+	r.listenersMu.Unlock()
+
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+// schedule updates the alert timers of the endpoint after a change, must be called under mu.
+func (r *Registry) schedule(ep *endpoint) {
+	for id, a := range r.alerts {
+		r.scheduleAlert(id, a, ep)
+	}
+}
+
+func (r *Registry) scheduleAlert(id uint64, a *alert, ep *endpoint) {
+	name, since := ep.info.Name, ep.info.UnreachableSince
+
+	if t, ok := a.timers[name]; ok {
+		if t.since.Equal(since) {
+			return
+		}
+
+		t.timer.Stop()
+		delete(a.timers, name)
+	}
+
+	if since.IsZero() {
+		return
+	}
+
+	a.timers[name] = alertTimer{
+		since: since,
+		timer: time.AfterFunc(time.Until(since.Add(a.after)), func() { r.fire(id, a, name, since) }),
+	}
+}
+
+// fire calls the alert when the endpoint is still in the same unreachable period.
+func (r *Registry) fire(id uint64, a *alert, name string, since time.Time) {
+	r.mu.RLock()
+
+	ep, ok := r.endpoints[name]
+	if !ok || r.alerts[id] != a || !ep.info.UnreachableSince.Equal(since) {
+		r.mu.RUnlock()
+
+		return
+	}
+
+	snapshot := ep.snapshot()
+
+	r.mu.RUnlock()
+
+	a.fn(snapshot)
+}
+
+func (a *alert) stop() {
+	for name, t := range a.timers {
+		t.timer.Stop()
+		delete(a.timers, name)
+	}
+}
+
+// bootstrap loads the endpoints of the available channel drivers, the lists of a driver
+// that is not loaded fail and leave its endpoints as they are. The lists are delivered with
+// the events and applied in the order Asterisk sent them, see handleList.
+func (r *Registry) bootstrap(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	defer cancel()
+
+	actions := []amiclient.Action{
+		amiclient.NewAction("SIPpeers"), amiclient.NewAction("PJSIPShowEndpoints"), amiclient.NewAction("PJSIPShowContacts"),
+	}
+	for i := range actions {
+		actions[i].Add("ActionID", r.client.NewActionID())
+	}
+
+	lists := listed{
+		peers:     actions[0].Get("ActionID"),
+		endpoints: actions[1].Get("ActionID"),
+		contacts:  actions[2].Get("ActionID"),
+		aors:      make(map[string]string),
+		previous:  make(map[string]*endpoint),
+	}
+	merge := amiclient.NewListMerge(lists.peers, lists.endpoints, lists.contacts)
+
+	r.mu.Lock()
+	r.merge, r.listed = merge, lists
+	r.mu.Unlock()
+
+	for _, action := range actions {
+		err := r.sub.DoList(ctx, action)
+		if errors.Is(err, amiclient.ErrActionFailed) {
+			logger.L().Debug("endpoint registry list failed", zap.String("action", action.Get("Action")), zap.Error(err))
+
+			continue
+		}
+
+		if err != nil {
+			logger.L().Warn("endpoint registry bootstrap failed", zap.Error(err))
+
+			r.mu.Lock()
+			if r.merge == merge {
+				r.merge = nil
+			}
+			r.mu.Unlock()
+
+			return
+		}
+	}
+}
+
+// handleList applies the messages of the bootstrap lists, it returns false for the events.
+// When the lists are done the endpoints and contacts of the loaded lists they missed are
+// removed unless an event read after the first list response changed them.
+func (r *Registry) handleList(msg amiclient.Message) bool {
+	var changes []Change
+
+	r.mu.Lock()
+
+	if r.merge == nil {
+		r.mu.Unlock()
+
+		return false
+	}
+
+	switch r.merge.Read(msg) {
+	case amiclient.ListEvent:
+		r.mu.Unlock()
+
+		return false
+	case amiclient.ListItem:
+		r.load(msg, time.Now())
+	case amiclient.ListDone:
+		changes = r.removeStale(time.Now())
+
+		if r.merge.Loaded(r.listed.peers) || r.merge.Loaded(r.listed.endpoints) {
+			r.once.Do(func() { close(r.bootstrapped) })
+		} else {
+			logger.L().Warn("endpoint registry bootstrap failed", zap.Error(ErrBootstrapFailed))
+		}
+
+		r.merge, r.listed = nil, listed{}
+	case amiclient.ListResponse, amiclient.ListComplete:
+	}
+
+	r.mu.Unlock()
+
+	for _, change := range changes {
+		r.notify(change)
+	}
+
+	return true
+}
+
+// load applies a list item, must be called under mu.
+func (r *Registry) load(item amiclient.Message, now time.Time) {
+	e, _ := events.Decode(item)
+
+	switch e := e.(type) {
+	case *events.PeerEntry:
+		if e.ObjectName == "" {
+			return
+		}
+
+		ep := r.touch(TechSIP + "/" + e.ObjectName)
+		ep.info.Address = sipAddress(e.IPaddress, e.IPport)
+		ep.info.Registered = ep.info.Address != ""
+
+		state, latency := parseSIPStatus(e.Status)
+		if e.Dynamic && !ep.info.Registered {
+			state, latency = StateUnreachable, 0
+		}
+
+		ep.info.Latency = latency
+		ep.setState(state, now)
+	case *events.EndpointList:
+		if e.ObjectName == "" {
+			return
+		}
+
+		ep := r.touch(TechPJSIP + "/" + e.ObjectName)
+		if e.DeviceState != "" {
+			ep.info.DeviceState = e.DeviceState
+		}
+
+		for _, aor := range strings.Split(e.Aor, ",") {
+			if aor = strings.TrimSpace(aor); aor != "" {
+				r.aors[aor] = e.ObjectName
+				r.listed.aors[aor] = e.ObjectName
+			}
+		}
+	case *events.ContactList:
+		name := e.Endpoint
+		if name == "" {
+			name = r.aors[e.Aor]
+		}
+
+		if name == "" || e.URI == "" {
+			return
+		}
+
+		ep := r.touch(TechPJSIP + "/" + name)
+		r.seen(ep.info.Name, e.URI)
+
+		c, ok := ep.contacts[e.URI]
+		if !ok {
+			c = &Contact{URI: e.URI}
+			ep.contacts[e.URI] = c
+		}
+
+		c.AOR, c.UserAgent, c.State, c.Latency = e.Aor, e.UserAgent, parseContactStatus(e.Status), 0
+		if c.State == StateReachable {
+			c.Latency = usec(e.RoundtripUsec)
+		}
+
+		if e.ViaAddr != "" {
+			c.Address = sipAddress(e.ViaAddr, e.ViaPort)
+		}
+
+		ep.aggregate(now)
+	}
+}
+
+// touch returns the endpoint listed by the running bootstrap keeping it as it was before,
+// must be called under mu.
+func (r *Registry) touch(name string) *endpoint {
+	r.seen(name)
+
+	ep, ok := r.endpoints[name]
+	if _, touched := r.listed.previous[name]; !touched {
+		r.listed.previous[name] = nil
+
+		if ok {
+			r.listed.previous[name] = ep.clone()
+		}
+	}
+
+	if !ok {
+		ep = r.endpoint(name)
+	}
+
+	return ep
+}
+
+// removeStale removes what the loaded lists missed and returns the endpoints changed by the
+// lists ordered by name, must be called under mu.
+func (r *Registry) removeStale(now time.Time) []Change {
+	lists := map[string]bool{
+		TechSIP:   r.merge.Loaded(r.listed.peers),
+		TechPJSIP: r.merge.Loaded(r.listed.endpoints),
+	}
+	changes := make([]Change, 0)
+
+	for name, ep := range r.endpoints {
+		if lists[ep.info.Technology] && r.stale(name) {
+			delete(r.endpoints, name)
+
+			for _, a := range r.alerts {
+				if t, ok := a.timers[name]; ok {
+					t.timer.Stop()
+					delete(a.timers, name)
+				}
+			}
+
+			changes = append(changes, Change{Endpoint: ep.snapshot(), Previous: ep.info.State, Removed: true})
+
+			continue
+		}
+
+		if ep.info.Technology != TechPJSIP || !r.merge.Loaded(r.listed.contacts) {
+			continue
+		}
+
+		for uri := range ep.contacts {
+			if r.stale(name, uri) {
+				r.touch(name)
+				delete(ep.contacts, uri)
+				ep.aggregate(now)
+			}
+		}
+	}
+
+	for name, previous := range r.listed.previous {
+		ep, ok := r.endpoints[name]
+		if !ok || previous != nil && !changed(previous, ep) {
+			continue
+		}
+
+		change := Change{Previous: StateUnknown}
+		if previous != nil {
+			change.Previous = previous.info.State
+		}
+
+		ep.info.Updated = now
+		r.schedule(ep)
+
+		change.Endpoint = ep.snapshot()
+		changes = append(changes, change)
+	}
+
+	if lists[TechPJSIP] {
+		r.aors = r.listed.aors
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Endpoint.Name < changes[j].Endpoint.Name })
+
+	return changes
+}
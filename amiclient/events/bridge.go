@@ -0,0 +1,34 @@
+package events
+
+type BridgeSnapshot struct {
+	BridgeUniqueid        string
+	BridgeType            string
+	BridgeTechnology      string
+	BridgeCreator         string
+	BridgeName            string
+	BridgeNumChannels     int
+	BridgeVideoSourceMode string
+}
+
+type BridgeCreate struct {
+	Base
+	BridgeSnapshot
+}
+
+type BridgeDestroy struct {
+	Base
+	BridgeSnapshot
+}
+
+type BridgeEnter struct {
+	Base
+	BridgeSnapshot
+	ChannelSnapshot
+	SwapUniqueid string
+}
+
+type BridgeLeave struct {
+	Base
+	BridgeSnapshot
+	ChannelSnapshot
+}
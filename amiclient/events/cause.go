@@ -0,0 +1,258 @@
+package events
+
+// HangupCause is the Q.850 cause code of the hangup events, see causes.h of Asterisk.
+type HangupCause int
+
+const (
+	CauseNotDefined                 HangupCause = 0
+	CauseUnallocated                HangupCause = 1
+	CauseNoRouteTransitNet          HangupCause = 2
+	CauseNoRouteDestination         HangupCause = 3
+	CauseMisdialledTrunkPrefix      HangupCause = 5
+	CauseChannelUnacceptable        HangupCause = 6
+	CauseCallAwardedDelivered       HangupCause = 7
+	CausePreEmpted                  HangupCause = 8
+	CauseNumberPortedNotHere        HangupCause = 14
+	CauseNormalClearing             HangupCause = 16
+	CauseUserBusy                   HangupCause = 17
+	CauseNoUserResponse             HangupCause = 18
+	CauseNoAnswer                   HangupCause = 19
+	CauseSubscriberAbsent           HangupCause = 20
+	CauseCallRejected               HangupCause = 21
+	CauseNumberChanged              HangupCause = 22
+	CauseRedirectedToNewDestination HangupCause = 23
+	CauseAnsweredElsewhere          HangupCause = 26
+	CauseDestinationOutOfOrder      HangupCause = 27
+	CauseInvalidNumberFormat        HangupCause = 28
+	CauseFacilityRejected           HangupCause = 29
+	CauseResponseToStatusEnquiry    HangupCause = 30
+	CauseNormalUnspecified          HangupCause = 31
+	CauseNormalCircuitCongestion    HangupCause = 34
+	CauseNetworkOutOfOrder          HangupCause = 38
+	CauseNormalTemporaryFailure     HangupCause = 41
+	CauseSwitchCongestion           HangupCause = 42
+	CauseAccessInfoDiscarded        HangupCause = 43
+	CauseRequestedChanUnavail       HangupCause = 44
+	CauseFacilityNotSubscribed      HangupCause = 50
+	CauseOutgoingCallBarred         HangupCause = 52
+	CauseIncomingCallBarred         HangupCause = 54
+	CauseBearerCapabilityNotAuth    HangupCause = 57
+	CauseBearerCapabilityNotAvail   HangupCause = 58
+	CauseBearerCapabilityNotImpl    HangupCause = 65
+	CauseChanNotImplemented         HangupCause = 66
+	CauseFacilityNotImplemented     HangupCause = 69
+	CauseInvalidCallReference       HangupCause = 81
+	CauseIncompatibleDestination    HangupCause = 88
+	CauseInvalidMsgUnspecified      HangupCause = 95
+	CauseMandatoryIEMissing         HangupCause = 96
+	CauseMessageTypeNonexist        HangupCause = 97
+	CauseWrongMessage               HangupCause = 98
+	CauseIENonexist                 HangupCause = 99
+	CauseInvalidIEContents          HangupCause = 100
+	CauseWrongCallState             HangupCause = 101
+	CauseRecoveryOnTimerExpire      HangupCause = 102
+	CauseMandatoryIELengthError     HangupCause = 103
+	CauseProtocolError              HangupCause = 111
+	CauseInterworking               HangupCause = 127
+)
+
+// CauseCategory groups the hangup causes by the call outcome. Only a normal clearing or an
+// answer counts as success, NORMAL_UNSPECIFIED comes with failed calls and is Other.
+type CauseCategory int
+
+const (
+	CategoryOther CauseCategory = iota
+	CategorySuccess
+	CategoryBusy
+	CategoryNoAnswer
+	CategoryInvalidNumber
+	CategoryNetworkFailure
+	CategoryCongestion
+	CategoryRejected
+)
+
+func (c CauseCategory) String() string {
+	switch c {
+	case CategorySuccess:
+		return "success"
+	case CategoryBusy:
+		return "busy"
+	case CategoryNoAnswer:
+		return "no-answer"
+	case CategoryInvalidNumber:
+		return "invalid-number"
+	case CategoryNetworkFailure:
+		return "network-failure"
+	case CategoryCongestion:
+		return "congestion"
+	case CategoryRejected:
+		return "rejected"
+	default:
+		return "other"
+	}
+}
+
+type causeInfo struct {
+	name        string
+	description string
+	category    CauseCategory
+}
+
+// causes are the names and descriptions of ast_cause2str, the descriptions are sent in Cause-txt.
+//
+//nolint:gochecknoglobals // cause table
+var causes = map[HangupCause]causeInfo{
+	CauseUnallocated:                {"UNALLOCATED", "Unallocated (unassigned) number", CategoryInvalidNumber},
+	CauseNoRouteTransitNet:          {"NO_ROUTE_TRANSIT_NET", "No route to specified transmit network", CategoryInvalidNumber},
+	CauseNoRouteDestination:         {"NO_ROUTE_DESTINATION", "No route to destination", CategoryInvalidNumber},
+	CauseMisdialledTrunkPrefix:      {"MISDIALLED_TRUNK_PREFIX", "Misdialed trunk prefix", CategoryInvalidNumber},
+	CauseChannelUnacceptable:        {"CHANNEL_UNACCEPTABLE", "Channel unacceptable", CategoryNetworkFailure},
+	CauseCallAwardedDelivered:       {"CALL_AWARDED_DELIVERED", "Call awarded and being delivered in an established channel", CategorySuccess},
+	CausePreEmpted:                  {"PRE_EMPTED", "Pre-empted", CategoryCongestion},
+	CauseNumberPortedNotHere:        {"NUMBER_PORTED_NOT_HERE", "Number ported elsewhere", CategoryInvalidNumber},
+	CauseNormalClearing:             {"NORMAL_CLEARING", "Normal Clearing", CategorySuccess},
+	CauseUserBusy:                   {"USER_BUSY", "User busy", CategoryBusy},
+	CauseNoUserResponse:             {"NO_USER_RESPONSE", "No user responding", CategoryNoAnswer},
+	CauseNoAnswer:                   {"NO_ANSWER", "User alerting, no answer", CategoryNoAnswer},
+	CauseSubscriberAbsent:           {"SUBSCRIBER_ABSENT", "Subscriber absent", CategoryNoAnswer},
+	CauseCallRejected:               {"CALL_REJECTED", "Call Rejected", CategoryRejected},
+	CauseNumberChanged:              {"NUMBER_CHANGED", "Number changed", CategoryInvalidNumber},
+	CauseRedirectedToNewDestination: {"REDIRECTED_TO_NEW_DESTINATION", "Redirected to new destination", CategoryOther},
+	CauseAnsweredElsewhere:          {"ANSWERED_ELSEWHERE", "Answered elsewhere", CategorySuccess},
+	CauseDestinationOutOfOrder:      {"DESTINATION_OUT_OF_ORDER", "Destination out of order", CategoryNetworkFailure},
+	CauseInvalidNumberFormat:        {"INVALID_NUMBER_FORMAT", "Invalid number format", CategoryInvalidNumber},
+	CauseFacilityRejected:           {"FACILITY_REJECTED", "Facility rejected", CategoryOther},
+	CauseResponseToStatusEnquiry:    {"RESPONSE_TO_STATUS_ENQUIRY", "Response to STATus ENQuiry", CategoryOther},
+	CauseNormalUnspecified:          {"NORMAL_UNSPECIFIED", "Normal, unspecified", CategoryOther},
+	CauseNormalCircuitCongestion:    {"NORMAL_CIRCUIT_CONGESTION", "Circuit/channel congestion", CategoryCongestion},
+	CauseNetworkOutOfOrder:          {"NETWORK_OUT_OF_ORDER", "Network out of order", CategoryNetworkFailure},
+	CauseNormalTemporaryFailure:     {"NORMAL_TEMPORARY_FAILURE", "Temporary failure", CategoryNetworkFailure},
+	CauseSwitchCongestion:           {"SWITCH_CONGESTION", "Switching equipment congestion", CategoryCongestion},
+	CauseAccessInfoDiscarded:        {"ACCESS_INFO_DISCARDED", "Access information discarded", CategoryNetworkFailure},
+	CauseRequestedChanUnavail:       {"REQUESTED_CHAN_UNAVAIL", "Requested channel not available", CategoryCongestion},
+	CauseFacilityNotSubscribed:      {"FACILITY_NOT_SUBSCRIBED", "Facility not subscribed", CategoryOther},
+	CauseOutgoingCallBarred:         {"OUTGOING_CALL_BARRED", "Outgoing call barred", CategoryOther},
+	CauseIncomingCallBarred:         {"INCOMING_CALL_BARRED", "Incoming call barred", CategoryOther},
+	CauseBearerCapabilityNotAuth:    {"BEARERCAPABILITY_NOTAUTH", "Bearer capability not authorized", CategoryOther},
+	CauseBearerCapabilityNotAvail:   {"BEARERCAPABILITY_NOTAVAIL", "Bearer capability not available", CategoryOther},
+	CauseBearerCapabilityNotImpl:    {"BEARERCAPABILITY_NOTIMPL", "Bearer capability not implemented", CategoryOther},
+	CauseChanNotImplemented:         {"CHAN_NOT_IMPLEMENTED", "Channel not implemented", CategoryOther},
+	CauseFacilityNotImplemented:     {"FACILITY_NOT_IMPLEMENTED", "Facility not implemented", CategoryOther},
+	CauseInvalidCallReference:       {"INVALID_CALL_REFERENCE", "Invalid call reference value", CategoryNetworkFailure},
+	CauseIncompatibleDestination:    {"INCOMPATIBLE_DESTINATION", "Incompatible destination", CategoryOther},
+	CauseInvalidMsgUnspecified:      {"INVALID_MSG_UNSPECIFIED", "Invalid message unspecified", CategoryNetworkFailure},
+	CauseMandatoryIEMissing:         {"MANDATORY_IE_MISSING", "Mandatory information element is missing", CategoryNetworkFailure},
+	CauseMessageTypeNonexist:        {"MESSAGE_TYPE_NONEXIST", "Message type nonexist.", CategoryNetworkFailure},
+	CauseWrongMessage:               {"WRONG_MESSAGE", "Wrong message", CategoryNetworkFailure},
+	CauseIENonexist:                 {"IE_NONEXIST", "Info. element nonexist or not implemented", CategoryNetworkFailure},
+	CauseInvalidIEContents:          {"INVALID_IE_CONTENTS", "Invalid information element contents", CategoryNetworkFailure},
+	CauseWrongCallState:             {"WRONG_CALL_STATE", "Message not compatible with call state", CategoryNetworkFailure},
+	CauseRecoveryOnTimerExpire:      {"RECOVERY_ON_TIMER_EXPIRE", "Recover on timer expiry", CategoryNetworkFailure},
+	CauseMandatoryIELengthError:     {"MANDATORY_IE_LENGTH_ERROR", "Mandatory IE length error", CategoryNetworkFailure},
+	CauseProtocolError:              {"PROTOCOL_ERROR", "Protocol error, unspecified", CategoryNetworkFailure},
+	CauseInterworking:               {"INTERWORKING", "Interworking, unspecified", CategoryNetworkFailure},
+}
+
+// Name is the Asterisk name of the cause like NORMAL_CLEARING, NOTDEFINED for unknown codes.
+func (c HangupCause) Name() string {
+	if info, ok := causes[c]; ok {
+		return info.name
+	}
+
+	return "NOTDEFINED"
+}
+
+// String is the description Asterisk sends in Cause-txt, "Unknown" for unknown codes.
+func (c HangupCause) String() string {
+	if info, ok := causes[c]; ok {
+		return info.description
+	}
+
+	return "Unknown"
+}
+
+func (c HangupCause) Category() CauseCategory {
+	return causes[c].category
+}
+
+// SIPResponse is the SIP response code chan_sip answers with on the cause
+// (hangup_cause2sip), 0 when there is no specific one.
+//
+//nolint:cyclop // mapping table
+func (c HangupCause) SIPResponse() int {
+	switch c {
+	case CauseUnallocated, CauseNoRouteDestination, CauseNoRouteTransitNet:
+		return 404
+	case CauseNormalCircuitCongestion, CauseSwitchCongestion, CauseChanNotImplemented:
+		return 503
+	case CauseNoUserResponse:
+		return 408
+	case CauseNoAnswer, CauseSubscriberAbsent, CauseNormalUnspecified:
+		return 480
+	case CauseCallRejected:
+		return 403
+	case CauseNumberChanged:
+		return 410
+	case CauseInvalidNumberFormat:
+		return 484
+	case CauseUserBusy:
+		return 486
+	case CauseNetworkOutOfOrder, CauseInterworking:
+		return 500
+	case CauseFacilityRejected:
+		return 501
+	case CauseDestinationOutOfOrder:
+		return 502
+	case CauseBearerCapabilityNotAvail:
+		return 488
+	default:
+		return 0
+	}
+}
+
+// CauseFromSIP is the cause chan_sip sets on a failure SIP response (hangup_sip2cause).
+//
+//nolint:cyclop // mapping table
+func CauseFromSIP(code int) HangupCause {
+	switch code {
+	case 401, 403, 407, 603:
+		return CauseCallRejected
+	case 404, 485, 604:
+		return CauseUnallocated
+	case 408:
+		return CauseNoUserResponse
+	case 409:
+		return CauseNormalTemporaryFailure
+	case 410:
+		return CauseNumberChanged
+	case 420:
+		return CauseNoRouteDestination
+	case 480, 483:
+		return CauseNoAnswer
+	case 484:
+		return CauseInvalidNumberFormat
+	case 486, 600:
+		return CauseUserBusy
+	case 488, 606:
+		return CauseBearerCapabilityNotAvail
+	case 500:
+		return CauseNetworkOutOfOrder
+	case 501:
+		return CauseFacilityRejected
+	case 502:
+		return CauseDestinationOutOfOrder
+	case 503:
+		return CauseNormalCircuitCongestion
+	case 504:
+		return CauseRecoveryOnTimerExpire
+	}
+
+	switch {
+	case code >= 400 && code < 500, code >= 600 && code < 700:
+		return CauseInterworking
+	case code >= 500 && code < 600:
+		return CauseNormalCircuitCongestion
+	default:
+		return CauseNormalClearing
+	}
+}
@@ -0,0 +1,47 @@
+package events
+
+type Cdr struct {
+	Base
+	AccountCode        string
+	Source             string
+	Destination        string
+	DestinationContext string
+	CallerID           string
+	Channel            string
+	DestinationChannel string
+	LastApplication    string
+	LastData           string
+	StartTime          string
+	AnswerTime         string
+	EndTime            string
+	Duration           int
+	BillableSeconds    int
+	Disposition        string
+	AMAFlags           string
+	UniqueID           string
+	UserField          string
+}
+
+type Cel struct {
+	Base
+	CELEvent      string `ami:"EventName"`
+	AccountCode   string
+	CallerIDnum   string
+	CallerIDname  string
+	CallerIDani   string
+	CallerIDrdnis string
+	CallerIDdnid  string
+	Exten         string
+	Context       string
+	Channel       string
+	Application   string
+	AppData       string
+	EventTime     string
+	AMAFlags      string
+	UniqueID      string
+	LinkedID      string
+	Userfield     string
+	Peer          string
+	PeerAccount   string
+	Extra         string
+}
@@ -0,0 +1,130 @@
+package events
+
+// ChannelSnapshot is the set of channel headers Asterisk attaches to channel events.
+type ChannelSnapshot struct {
+	Channel           string
+	ChannelState      int
+	ChannelStateDesc  string
+	CallerIDNum       string
+	CallerIDName      string
+	ConnectedLineNum  string
+	ConnectedLineName string
+	Language          string
+	AccountCode       string
+	Context           string
+	Exten             string
+	Priority          int
+	Uniqueid          string
+	Linkedid          string
+}
+
+type Newchannel struct {
+	Base
+	ChannelSnapshot
+}
+
+type Newstate struct {
+	Base
+	ChannelSnapshot
+}
+
+type Newexten struct {
+	Base
+	ChannelSnapshot
+	Extension   string
+	Application string
+	AppData     string
+}
+
+type NewCallerid struct {
+	Base
+	ChannelSnapshot
+	CIDCallingPres string `ami:"CID-CallingPres"`
+}
+
+type Rename struct {
+	Base
+	ChannelSnapshot
+	Newname string
+}
+
+type Hangup struct {
+	Base
+	ChannelSnapshot
+	Cause    HangupCause
+	CauseTxt string `ami:"Cause-txt"`
+}
+
+type HangupRequest struct {
+	Base
+	ChannelSnapshot
+	Cause HangupCause
+}
+
+type SoftHangupRequest struct {
+	Base
+	ChannelSnapshot
+	Cause HangupCause
+}
+
+type VarSet struct {
+	Base
+	ChannelSnapshot
+	Variable string
+	Value    string
+}
+
+type DTMFBegin struct {
+	Base
+	ChannelSnapshot
+	Digit     string
+	Direction string
+}
+
+type DTMFEnd struct {
+	Base
+	ChannelSnapshot
+	Digit      string
+	DurationMs int
+	Direction  string
+}
+
+// DialBegin carries the caller snapshot and the dialed channel snapshot with Dest prefix.
+type DialBegin struct {
+	Base
+	ChannelSnapshot
+	Dest       ChannelSnapshot
+	DialString string
+}
+
+type DialEnd struct {
+	Base
+	ChannelSnapshot
+	Dest       ChannelSnapshot
+	DialStatus string
+	Forward    string
+}
+
+// CoreShowChannel is an item of the CoreShowChannels list.
+type CoreShowChannel struct {
+	Base
+	ChannelSnapshot
+	BridgeID        string `ami:"BridgeId"`
+	Application     string
+	ApplicationData string
+	Duration        string
+}
+
+type OriginateResponse struct {
+	Base
+	Response     string
+	Channel      string
+	Context      string
+	Exten        string
+	Application  string
+	Data         string
+	Reason       int
+	Uniqueid     string
+	CallerIDNum  string
+	CallerIDName string
+}
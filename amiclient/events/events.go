@@ -0,0 +1,128 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Arten331/telephony/amiclient"
+)
+
+var (
+	ErrNotEvent     = errors.New("message is not an event")
+	ErrUnknownEvent = errors.New("unknown event")
+)
+
+type Event interface {
+	EventName() string
+}
+
+// Base holds the headers common to all events.
+type Base struct {
+	Event     string
+	Privilege string
+	ActionID  string
+}
+
+func (b Base) EventName() string {
+	return b.Event
+}
+
+//nolint:gochecknoglobals // event registry
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]func() Event{}
+)
+
+// Register adds a decoder for the event name, names are matched case-insensitively.
+// Registering an existing name replaces the built-in type.
+func Register(name string, factory func() Event) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[strings.ToLower(name)] = factory
+}
+
+// Decode converts the message to its typed event, ErrUnknownEvent is returned for events
+// without a registered type. Headers that fail to parse are reported together with the
+// event decoded from the other headers, see amiclient.Unmarshal.
+func Decode(msg amiclient.Message) (Event, error) {
+	name, ok := msg.Lookup("Event")
+	if !ok {
+		return nil, ErrNotEvent
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[strings.ToLower(name)]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, name)
+	}
+
+	e := factory()
+
+	err := amiclient.Unmarshal(msg, e)
+	if err != nil {
+		return e, fmt.Errorf("decode %s: %w", name, err)
+	}
+
+	return e, nil
+}
+
+func init() {
+	for name, factory := range map[string]func() Event{
+		"Newchannel":        func() Event { return &Newchannel{} },
+		"Newstate":          func() Event { return &Newstate{} },
+		"Newexten":          func() Event { return &Newexten{} },
+		"NewCallerid":       func() Event { return &NewCallerid{} },
+		"Rename":            func() Event { return &Rename{} },
+		"Hangup":            func() Event { return &Hangup{} },
+		"HangupRequest":     func() Event { return &HangupRequest{} },
+		"SoftHangupRequest": func() Event { return &SoftHangupRequest{} },
+		"VarSet":            func() Event { return &VarSet{} },
+		"DTMFBegin":         func() Event { return &DTMFBegin{} },
+		"DTMFEnd":           func() Event { return &DTMFEnd{} },
+		"DialBegin":         func() Event { return &DialBegin{} },
+		"DialEnd":           func() Event { return &DialEnd{} },
+		"OriginateResponse": func() Event { return &OriginateResponse{} },
+		"CoreShowChannel":   func() Event { return &CoreShowChannel{} },
+		"BridgeCreate":      func() Event { return &BridgeCreate{} },
+		"BridgeDestroy":     func() Event { return &BridgeDestroy{} },
+		"BridgeEnter":       func() Event { return &BridgeEnter{} },
+		"BridgeLeave":       func() Event { return &BridgeLeave{} },
+		"BlindTransfer":     func() Event { return &BlindTransfer{} },
+		"AttendedTransfer":  func() Event { return &AttendedTransfer{} },
+
+		"LocalOptimizationBegin": func() Event { return &LocalOptimizationBegin{} },
+		"LocalOptimizationEnd":   func() Event { return &LocalOptimizationEnd{} },
+
+		"FullyBooted":       func() Event { return &FullyBooted{} },
+		"PeerStatus":        func() Event { return &PeerStatus{} },
+		"ContactStatus":     func() Event { return &ContactStatus{} },
+		"DeviceStateChange": func() Event { return &DeviceStateChange{} },
+		"QueueMemberStatus": func() Event { return &QueueMemberStatus{} },
+		"QueueParams":       func() Event { return &QueueParams{} },
+		"QueueMember":       func() Event { return &QueueMember{} },
+		"QueueEntry":        func() Event { return &QueueEntry{} },
+		"QueueSummary":      func() Event { return &QueueSummary{} },
+		"AgentConnect":      func() Event { return &AgentConnect{} },
+		"AgentComplete":     func() Event { return &AgentComplete{} },
+
+		"QueueMemberAdded":   func() Event { return &QueueMemberAdded{} },
+		"QueueMemberRemoved": func() Event { return &QueueMemberRemoved{} },
+		"QueueMemberPause":   func() Event { return &QueueMemberPause{} },
+		"QueueCallerJoin":    func() Event { return &QueueCallerJoin{} },
+		"QueueCallerLeave":   func() Event { return &QueueCallerLeave{} },
+		"QueueCallerAbandon": func() Event { return &QueueCallerAbandon{} },
+		"PeerEntry":          func() Event { return &PeerEntry{} },
+		"EndpointList":       func() Event { return &EndpointList{} },
+		"ContactList":        func() Event { return &ContactList{} },
+
+		"Cdr": func() Event { return &Cdr{} },
+		"CEL": func() Event { return &Cel{} },
+	} {
+		Register(name, factory)
+	}
+}
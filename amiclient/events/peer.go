@@ -0,0 +1,48 @@
+package events
+
+// PeerEntry is an item of the chan_sip SIPpeers list. Status is the qualify result,
+// for example "OK (5 ms)", "LAGGED (2100 ms)", "UNREACHABLE" or "Unmonitored".
+type PeerEntry struct {
+	Base
+	Channeltype    string
+	ObjectName     string
+	ChanObjectType string
+	IPaddress      string
+	IPport         int
+	Dynamic        bool
+	Status         string
+	Description    string
+}
+
+// EndpointList is an item of the PJSIPShowEndpoints list, Aor and Contacts are comma separated.
+type EndpointList struct {
+	Base
+	ObjectType     string
+	ObjectName     string
+	Transport      string
+	Aor            string
+	Auths          string
+	OutboundAuths  string
+	Contacts       string
+	DeviceState    string
+	ActiveChannels string
+}
+
+// ContactList is an item of the PJSIPShowContacts list. RoundtripUsec is "N/A" for
+// contacts never qualified.
+type ContactList struct {
+	Base
+	ObjectType     string
+	ObjectName     string
+	ViaAddr        string
+	ViaPort        int
+	CallID         string `ami:"CallId"`
+	Endpoint       string
+	URI            string `ami:"Uri"`
+	UserAgent      string
+	ExpirationTime int64
+	Status         string
+	RoundtripUsec  string
+	Aor            string
+	ID             string `ami:"Id"`
+}
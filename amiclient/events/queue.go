@@ -0,0 +1,118 @@
+package events
+
+// QueueParams is a queue item of the QueueStatus list.
+type QueueParams struct {
+	Base
+	Queue            string
+	Max              int
+	Strategy         string
+	Calls            int
+	Holdtime         int
+	TalkTime         int
+	Completed        int
+	Abandoned        int
+	ServiceLevel     int
+	ServicelevelPerf float64
+	Weight           int
+}
+
+// QueueMember is a member item of the QueueStatus list.
+type QueueMember struct {
+	Base
+	Queue          string
+	Name           string
+	Location       string
+	StateInterface string
+	Membership     string
+	Penalty        int
+	CallsTaken     int
+	LastCall       int64
+	LastPause      int64
+	InCall         bool
+	Status         int
+	Paused         bool
+	PausedReason   string
+	Wrapuptime     int
+}
+
+// QueueEntry is a waiting caller item of the QueueStatus list, Wait is in seconds.
+type QueueEntry struct {
+	Base
+	Queue             string
+	Position          int
+	Channel           string
+	Uniqueid          string
+	CallerIDNum       string
+	CallerIDName      string
+	ConnectedLineNum  string
+	ConnectedLineName string
+	Wait              int
+	Priority          int
+}
+
+// QueueSummary is an item of the QueueSummary list, times are in seconds.
+type QueueSummary struct {
+	Base
+	Queue           string
+	LoggedIn        int
+	Available       int
+	Callers         int
+	HoldTime        int
+	TalkTime        int
+	LongestHoldTime int
+}
+
+type QueueMemberAdded QueueMemberStatus
+
+type QueueMemberRemoved QueueMemberStatus
+
+type QueueMemberPause QueueMemberStatus
+
+type QueueCallerJoin struct {
+	Base
+	ChannelSnapshot
+	Queue    string
+	Position int
+	Count    int
+}
+
+type QueueCallerLeave struct {
+	Base
+	ChannelSnapshot
+	Queue    string
+	Position int
+	Count    int
+}
+
+type QueueCallerAbandon struct {
+	Base
+	ChannelSnapshot
+	Queue            string
+	Position         int
+	OriginalPosition int
+	HoldTime         int
+}
+
+// AgentConnect carries the caller snapshot and the member channel snapshot with Dest prefix.
+type AgentConnect struct {
+	Base
+	ChannelSnapshot
+	Dest       ChannelSnapshot
+	Queue      string
+	MemberName string
+	Interface  string
+	HoldTime   int
+	RingTime   int
+}
+
+type AgentComplete struct {
+	Base
+	ChannelSnapshot
+	Dest       ChannelSnapshot
+	Queue      string
+	MemberName string
+	Interface  string
+	HoldTime   int
+	TalkTime   int
+	Reason     string
+}
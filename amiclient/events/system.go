@@ -0,0 +1,60 @@
+package events
+
+type FullyBooted struct {
+	Base
+	Status     string
+	Uptime     int
+	LastReload int
+}
+
+// PeerStatus is sent by chan_sip, chan_iax2 and chan_pjsip on registration changes.
+type PeerStatus struct {
+	Base
+	ChannelType string
+	Peer        string
+	PeerStatus  string
+	Cause       string
+	Address     string
+	Port        int
+	Time        int
+}
+
+// ContactStatus is sent by chan_pjsip when a contact is created, reachable, unreachable or removed.
+type ContactStatus struct {
+	Base
+	URI           string
+	ContactStatus string
+	AOR           string
+	EndpointName  string
+	RoundtripUsec int64
+	UserAgent     string
+	RegExpire     int64
+	ViaAddress    string
+	CallID        string
+}
+
+type DeviceStateChange struct {
+	Base
+	Device string
+	State  string
+}
+
+type QueueMemberStatus struct {
+	Base
+	Queue          string
+	MemberName     string
+	Interface      string
+	StateInterface string
+	Membership     string
+	Penalty        int
+	CallsTaken     int
+	LastCall       int64
+	LastPause      int64
+	LoginTime      int64
+	InCall         bool
+	Status         int
+	Paused         bool
+	PausedReason   string
+	Ringinuse      bool
+	Wrapuptime     int
+}
@@ -0,0 +1,53 @@
+package events
+
+// BlindTransfer headers carry the transferer channel and bridge with the Transferer prefix
+// and the transferee channel with the Transferee prefix.
+type BlindTransfer struct {
+	Base
+	Result           string
+	Transferer       ChannelSnapshot
+	TransfererBridge BridgeSnapshot `ami:"Transferer"`
+	Transferee       ChannelSnapshot
+	IsExternal       bool
+	Context          string
+	Extension        string
+}
+
+// AttendedTransfer joins the bridges of the original and the consultation calls,
+// DestType is Bridge, App, Link, Threeway or Fail.
+type AttendedTransfer struct {
+	Base
+	Result                string
+	OrigTransferer        ChannelSnapshot
+	OrigBridge            BridgeSnapshot `ami:"Orig"`
+	SecondTransferer      ChannelSnapshot
+	SecondBridge          BridgeSnapshot `ami:"Second"`
+	TransferTarget        ChannelSnapshot
+	Transferee            ChannelSnapshot
+	DestType              string
+	DestBridgeUniqueid    string
+	DestApp               string
+	IsExternal            bool
+	DestTransfererChannel string
+	LocalOneChannel       string
+	LocalTwoChannel       string
+}
+
+// LocalOptimizationBegin is sent when the two halves of a Local channel are going to be
+// optimized away, the channels bridged to them are connected directly.
+type LocalOptimizationBegin struct {
+	Base
+	LocalOne     ChannelSnapshot
+	LocalTwo     ChannelSnapshot
+	Source       ChannelSnapshot
+	DestUniqueid string `ami:"DestUniqueId"`
+	ID           int    `ami:"Id"`
+}
+
+type LocalOptimizationEnd struct {
+	Base
+	LocalOne ChannelSnapshot
+	LocalTwo ChannelSnapshot
+	Success  bool
+	ID       int `ami:"Id"`
+}
@@ -0,0 +1,87 @@
+package amiclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// EventMask returns the event classes requested on login ("on", "off", "call,system").
+func (c *Client) EventMask() string {
+	c.filtersMu.Lock()
+	defer c.filtersMu.Unlock()
+
+	return c.eventMask
+}
+
+// Filters returns the server-side filters applied on every login.
+func (c *Client) Filters() []string {
+	c.filtersMu.Lock()
+	defer c.filtersMu.Unlock()
+
+	return append([]string(nil), c.filters...)
+}
+
+// SetEventMask changes the event classes of the current session, the mask is also used on reconnect.
+func (c *Client) SetEventMask(ctx context.Context, mask string) error {
+	action := NewAction("Events")
+	action.Add("EventMask", mask)
+
+	_, err := c.Do(ctx, action)
+	if err != nil {
+		return err
+	}
+
+	c.filtersMu.Lock()
+	c.eventMask = mask
+	c.filtersMu.Unlock()
+
+	return nil
+}
+
+// AddFilter adds a server-side filter ("Event: Hangup", "!Channel: Local/.*") to the current session,
+// the filter is also applied on reconnect. Asterisk has no way to remove a filter without a new login.
+func (c *Client) AddFilter(ctx context.Context, filter string) error {
+	_, err := c.Do(ctx, filterAction(filter))
+	if err != nil {
+		return err
+	}
+
+	c.filtersMu.Lock()
+	c.filters = append(c.filters, filter)
+	c.filtersMu.Unlock()
+
+	return nil
+}
+
+// applyFilters sends the stored filters after login, the reader loop is not running yet.
+func (c *Client) applyFilters(ctx context.Context) error {
+	filters := c.Filters()
+	if len(filters) == 0 {
+		return nil
+	}
+
+	ctx, cancel := c.handshakeContext(ctx, time.Second*10)
+	defer cancel()
+
+	for _, filter := range filters {
+		msg, err := c.handshake(ctx, filterAction(filter))
+		if err != nil {
+			return err
+		}
+
+		if err = responseError(msg); err != nil {
+			return fmt.Errorf("filter %q: %w", filter, err)
+		}
+	}
+
+	return nil
+}
+
+func filterAction(filter string) Action {
+	action := NewAction("Filter")
+	action.Add("Operation", "Add")
+	action.Add("Filter", filter)
+
+	return action
+}
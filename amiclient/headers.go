@@ -0,0 +1,158 @@
+package amiclient
+
+import (
+	"sort"
+	"strings"
+)
+
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Headers keeps AMI headers in wire order and allows repeated keys
+// (Variable on Originate, ChanVariable on events). Keys are matched case-insensitively.
+type Headers []Header
+
+// NewAction returns an action with the Action header set.
+func NewAction(name string) Action {
+	return Action{{Key: "Action", Value: name}}
+}
+
+// ActionFromMap converts the map representation used before ordered headers.
+func ActionFromMap(m map[string]string) Action {
+	return headersFromMap(m)
+}
+
+// MessageFromMap converts the map representation used before ordered headers.
+func MessageFromMap(m map[string]string) Message {
+	return headersFromMap(m)
+}
+
+// headersFromMap puts Action, Event and Response first, the rest is sorted by key.
+func headersFromMap(m map[string]string) Headers {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		li, lj := leadingKey(keys[i]), leadingKey(keys[j])
+		if li != lj {
+			return li
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	h := make(Headers, 0, len(keys))
+	for _, k := range keys {
+		h = append(h, Header{Key: k, Value: m[k]})
+	}
+
+	return h
+}
+
+func leadingKey(key string) bool {
+	return key == "Action" || key == "Event" || key == "Response"
+}
+
+// Get returns the first value of the key.
+func (h Headers) Get(key string) string {
+	v, _ := h.Lookup(key)
+
+	return v
+}
+
+func (h Headers) Lookup(key string) (string, bool) {
+	for i := range h {
+		if strings.EqualFold(h[i].Key, key) {
+			return h[i].Value, true
+		}
+	}
+
+	return "", false
+}
+
+func (h Headers) Has(key string) bool {
+	_, ok := h.Lookup(key)
+
+	return ok
+}
+
+// GetAll returns every value of the key in wire order.
+func (h Headers) GetAll(key string) []string {
+	var values []string
+
+	for i := range h {
+		if strings.EqualFold(h[i].Key, key) {
+			values = append(values, h[i].Value)
+		}
+	}
+
+	return values
+}
+
+// Add appends the header, existing values of the key are kept.
+func (h *Headers) Add(key, value string) {
+	*h = append(*h, Header{Key: key, Value: value})
+}
+
+// Set replaces the first value of the key and removes the others, appends when the key is missing.
+func (h *Headers) Set(key, value string) {
+	found := false
+	res := (*h)[:0]
+
+	for _, header := range *h {
+		if strings.EqualFold(header.Key, key) {
+			if found {
+				continue
+			}
+
+			found = true
+			header.Value = value
+		}
+
+		res = append(res, header)
+	}
+
+	if !found {
+		res = append(res, Header{Key: key, Value: value})
+	}
+
+	*h = res
+}
+
+func (h *Headers) Del(key string) {
+	res := (*h)[:0]
+
+	for _, header := range *h {
+		if !strings.EqualFold(header.Key, key) {
+			res = append(res, header)
+		}
+	}
+
+	*h = res
+}
+
+func (h Headers) Clone() Headers {
+	if h == nil {
+		return nil
+	}
+
+	res := make(Headers, len(h))
+	copy(res, h)
+
+	return res
+}
+
+// Map converts headers to the map representation, the first value of a repeated key wins.
+func (h Headers) Map() map[string]string {
+	m := make(map[string]string, len(h))
+
+	for i := len(h) - 1; i >= 0; i-- {
+		m[h[i].Key] = h[i].Value
+	}
+
+	return m
+}
@@ -0,0 +1,68 @@
+package amiclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/Arten331/observability/logger"
+	"go.uber.org/zap"
+)
+
+// keepalive pings the server every Settings.PingInterval and closes the connection when a pong
+// does not come within Settings.PingTimeout, so half-open connections are detected by the reader.
+func (c *Client) keepalive(ctx context.Context, conn net.Conn, stop, dead chan struct{}) {
+	timeout := c.settings.PingTimeout
+	if timeout <= 0 {
+		timeout = c.settings.PingInterval
+	}
+
+	ticker := time.NewTicker(c.settings.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		latency, err := c.ping(ctx, timeout)
+		if err == nil {
+			c.metrics.ObservePingLatency(latency)
+
+			continue
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		logger.L().Warn("AMI ping failed, closing connection", zap.Error(err))
+
+		close(dead)
+		_ = conn.Close()
+
+		return
+	}
+}
+
+func (c *Client) ping(ctx context.Context, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err := c.Do(ctx, NewAction("Ping"))
+	if errors.Is(err, ErrActionFailed) {
+		// any response proves the connection is alive
+		err = nil
+	}
+
+	return time.Since(start), err
+}
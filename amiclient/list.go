@@ -0,0 +1,296 @@
+package amiclient
+
+import (
+	"context"
+	"strings"
+)
+
+// ListStream iterates over events of a list action (CoreShowChannels, QueueStatus, ...)
+// until the terminating EventList: Complete event.
+type ListStream struct {
+	c        *Client
+	ctx      context.Context
+	id       string
+	p        *pendingAction
+	response Message
+	complete Message
+	msg      Message
+	err      error
+	done     bool
+}
+
+// DoList sends a list action and collects all events up to the completion marker.
+func (c *Client) DoList(ctx context.Context, action Action) ([]Message, error) {
+	s, err := c.DoListStream(ctx, action)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	msgs := make([]Message, 0)
+
+	for s.Next() {
+		msgs = append(msgs, s.Message())
+	}
+
+	return msgs, s.Err()
+}
+
+// DoListStream sends a list action and waits for its initial response.
+// The stream must be closed when the caller stops reading before completion.
+func (c *Client) DoListStream(ctx context.Context, action Action) (*ListStream, error) {
+	action, id := c.withActionID(action)
+
+	p, err := c.registerPending(id, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ListStream{
+		c:   c,
+		ctx: ctx,
+		id:  id,
+		p:   p,
+	}
+
+	err = c.SendCommandContext(ctx, action)
+	if err != nil {
+		s.Close()
+
+		return nil, err
+	}
+
+	s.response, err = p.next(ctx)
+	if err == nil {
+		err = responseError(s.response)
+	}
+
+	if err != nil {
+		s.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// DoList sends a list action and delivers its response, events and completion event to the
+// subscription, bypassing its filter, in the order they were read among the other messages,
+// so a handler applies the list and the events around it in the order Asterisk sent them.
+// The ActionID of the action tells the list messages apart, see Client.NewActionID.
+// DoList returns when the list is complete.
+func (s *Subscription) DoList(ctx context.Context, action Action) error {
+	action, id := s.c.withActionID(action)
+
+	p, err := s.c.registerPending(id, true, s)
+	if err != nil {
+		return err
+	}
+	defer s.c.unregisterPending(id)
+
+	err = s.c.SendCommandContext(ctx, action)
+	if err != nil {
+		return err
+	}
+
+	msg, err := p.next(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = responseError(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.next(ctx)
+
+	return err
+}
+
+func (s *ListStream) Next() bool {
+	if s.done {
+		return false
+	}
+
+	msg, err := s.p.next(s.ctx)
+	if err != nil {
+		s.err = err
+		s.Close()
+
+		return false
+	}
+
+	if isListComplete(msg) {
+		s.complete = msg
+		s.Close()
+
+		return false
+	}
+
+	s.msg = msg
+
+	return true
+}
+
+// Message returns the current list event.
+func (s *ListStream) Message() Message {
+	return s.msg
+}
+
+// Response returns the initial response of the list action.
+func (s *ListStream) Response() Message {
+	return s.response
+}
+
+// Complete returns the terminating event, nil until the list is read to the end.
+func (s *ListStream) Complete() Message {
+	return s.complete
+}
+
+func (s *ListStream) Err() error {
+	return s.err
+}
+
+func (s *ListStream) Close() {
+	if s.done {
+		return
+	}
+
+	s.done = true
+	s.c.unregisterPending(s.id)
+}
+
+func isListComplete(msg Message) bool {
+	if strings.EqualFold(msg.Get("EventList"), "Complete") {
+		return true
+	}
+
+	event, isEvent := msg.Lookup("Event")
+
+	return !isEvent || strings.HasSuffix(event, "Complete")
+}
+
+// ListMessage is the kind of a message read by ListMerge.
+type ListMessage int
+
+const (
+	// ListEvent is a message of the event stream.
+	ListEvent ListMessage = iota
+	// ListResponse is the response of a list, failed or not.
+	ListResponse
+	// ListItem is an event of a list.
+	ListItem
+	// ListComplete ends a list, other lists are still expected.
+	ListComplete
+	// ListDone ends the last expected list, see Loaded for the lists that failed.
+	ListDone
+)
+
+// ListMerge merges lists loaded with Subscription.DoList into the model kept by the subscription
+// handler. The handler passes every message to Read and applies the list items like events, the
+// order of the stream decides which one is newer. The entries listed and those changed by an event
+// read after the first list response are Seen, the others are stale when the lists are done.
+// ListMerge is not safe for concurrent use.
+type ListMerge struct {
+	lists   map[string]*listProgress
+	pending int
+	started bool
+	seen    map[string]struct{}
+}
+
+type listProgress struct {
+	ended  bool
+	loaded bool
+}
+
+// NewListMerge expects the lists sent with the ActionIDs.
+func NewListMerge(ids ...string) *ListMerge {
+	m := &ListMerge{
+		lists: make(map[string]*listProgress, len(ids)),
+		seen:  make(map[string]struct{}),
+	}
+
+	for _, id := range ids {
+		if _, ok := m.lists[id]; !ok {
+			m.lists[id] = &listProgress{}
+			m.pending++
+		}
+	}
+
+	return m
+}
+
+// Read records the message and tells its kind.
+func (m *ListMerge) Read(msg Message) ListMessage {
+	id, ok := msg.Lookup("ActionID")
+	if !ok {
+		return ListEvent
+	}
+
+	list, ok := m.lists[id]
+	if !ok || list.ended {
+		return ListEvent
+	}
+
+	response := !msg.Has("Event")
+	if !response && !isListComplete(msg) {
+		return ListItem
+	}
+
+	// the list ends with an error response or with the completion event
+	if response {
+		m.started = true
+
+		if responseError(msg) == nil {
+			return ListResponse
+		}
+	} else {
+		list.loaded = true
+	}
+
+	list.ended = true
+
+	if m.pending--; m.pending == 0 {
+		return ListDone
+	}
+
+	if list.loaded {
+		return ListComplete
+	}
+
+	return ListResponse
+}
+
+// Complete reports whether all the lists were read to the end.
+func (m *ListMerge) Complete() bool {
+	for _, list := range m.lists {
+		if !list.loaded {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Loaded reports whether the list was read to the end.
+func (m *ListMerge) Loaded(id string) bool {
+	list, ok := m.lists[id]
+
+	return ok && list.loaded
+}
+
+// Seen marks the entry as up to date, it is ignored before the first list response:
+// the lists are newer than the events read before.
+func (m *ListMerge) Seen(key string) {
+	if m.started {
+		m.seen[key] = struct{}{}
+	}
+}
+
+// Stale reports whether the entry is neither listed nor changed since the first list response.
+func (m *ListMerge) Stale(key string) bool {
+	_, ok := m.seen[key]
+
+	return !ok
+}
@@ -1,6 +1,8 @@
 package amiclient
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -8,6 +10,8 @@ type Metrics struct {
 	messagesReceived *prometheus.CounterVec
 	messagesSent     *prometheus.CounterVec
 	connectionsTry   *prometheus.CounterVec
+	messagesDropped  *prometheus.CounterVec
+	pingLatency      *prometheus.HistogramVec
 }
 
 func newMetrics(service string) *Metrics {
@@ -33,6 +37,21 @@ func newMetrics(service string) *Metrics {
 			},
 			[]string{},
 		),
+		messagesDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: service,
+				Name:      "ami_messages_dropped",
+			},
+			[]string{"policy"},
+		),
+		pingLatency: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: service,
+				Name:      "ami_ping_latency_seconds",
+				Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+			},
+			[]string{},
+		),
 	}
 
 	return m
@@ -43,6 +62,8 @@ func (m *Metrics) getMetrics() []prometheus.Collector {
 		m.messagesSent,
 		m.messagesReceived,
 		m.connectionsTry,
+		m.messagesDropped,
+		m.pingLatency,
 	}
 
 	return collectors
@@ -59,3 +80,11 @@ func (m *Metrics) StoreReceivedMessage() {
 func (m *Metrics) StoreConnectionCount() {
 	m.connectionsTry.WithLabelValues().Inc()
 }
+
+func (m *Metrics) StoreDroppedMessage(policy string) {
+	m.messagesDropped.WithLabelValues(policy).Inc()
+}
+
+func (m *Metrics) ObservePingLatency(latency time.Duration) {
+	m.pingLatency.WithLabelValues().Observe(latency.Seconds())
+}
@@ -0,0 +1,228 @@
+package amiclient
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+const messagePoolCap = 32
+
+//nolint:gochecknoglobals // read only tables and pool
+var (
+	carriageReturn = []byte{'\r'}
+
+	// commonKeys interns the header keys seen in most messages, the lookup by string(b) does not allocate.
+	commonKeys = internKeys(
+		"Event", "Response", "ActionID", "Message", "Privilege", "Timestamp", "SequenceNumber",
+		"File", "Line", "Func", "EventList", "ListItems", "Output", "Ping",
+		"Channel", "ChannelState", "ChannelStateDesc", "CallerIDNum", "CallerIDName",
+		"ConnectedLineNum", "ConnectedLineName", "Language", "AccountCode", "Context", "Exten",
+		"Priority", "Uniqueid", "Linkedid", "Cause", "Cause-txt", "Variable", "Value",
+		"DestChannel", "DestChannelState", "DestChannelStateDesc", "DestCallerIDNum", "DestCallerIDName",
+		"DestConnectedLineNum", "DestConnectedLineName", "DestLanguage", "DestAccountCode",
+		"DestContext", "DestExten", "DestPriority", "DestUniqueid", "DestLinkedid",
+		"DialString", "DialStatus", "BridgeUniqueid", "BridgeType", "BridgeTechnology",
+		"BridgeCreator", "BridgeName", "BridgeNumChannels", "BridgeVideoSourceMode",
+		"Status", "ChannelType", "Peer", "PeerStatus", "Address",
+		"Queue", "Interface", "MemberName", "StateInterface", "Membership", "Penalty",
+		"CallsTaken", "LastCall", "LastPause", "InCall", "Paused", "PausedReason", "Ringinuse",
+		"Device", "State", "Endpoint", "AOR", "URI", "RoundtripUsec", "ContactStatus",
+		"Application", "AppData", "Duration", "BridgeId",
+	)
+
+	messagePool = sync.Pool{
+		New: func() interface{} {
+			msg := make(Message, 0, messagePoolCap)
+
+			return &msg
+		},
+	}
+)
+
+func internKeys(keys ...string) map[string]string {
+	m := make(map[string]string, len(keys))
+
+	for _, k := range keys {
+		m[k] = k
+	}
+
+	return m
+}
+
+// releaseMessage returns a message read by Parser to the pool. A message may be delivered to
+// MsgChan and to several subscriptions, so the client only releases the messages it handed to
+// nobody, see Client.handleMessage.
+func releaseMessage(msg Message) {
+	if cap(msg) == 0 || cap(msg) > 4*messagePoolCap {
+		return
+	}
+
+	msg = msg[:cap(msg)]
+	for i := range msg {
+		msg[i] = Header{}
+	}
+
+	msg = msg[:0]
+	messagePool.Put(&msg)
+}
+
+// span points to a header inside the raw message bytes.
+type span struct {
+	key        string
+	keyStart   int
+	keyEnd     int
+	valueStart int
+	valueEnd   int
+}
+
+// Parser reads messages directly from a bufio.Reader. Header bytes of a message are collected
+// in a reused buffer and converted to a single string, keys and values are substrings of it,
+// common keys are interned. A Parser keeps a partially read message over read timeouts.
+type Parser struct {
+	// Strict makes Next fail with ErrMalformedHeader on a message having a line without a colon,
+	// the whole message is consumed so the following messages are read normally.
+	Strict bool
+
+	r         *bufio.Reader
+	line      []byte
+	raw       []byte
+	spans     []span
+	output    commandOutput
+	malformed error
+}
+
+func NewParser(r *bufio.Reader) *Parser {
+	return &Parser{r: r}
+}
+
+// Next returns the next message. A read timeout returns a nil message and a nil error,
+// the message read so far is completed by the following calls.
+func (p *Parser) Next() (Message, error) {
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, nil
+			}
+
+			if err == io.EOF && len(p.spans) != 0 && p.malformed == nil {
+				return p.message(), nil
+			}
+
+			p.reset()
+
+			return nil, err
+		}
+
+		if p.output.consume(line) {
+			continue
+		}
+
+		if len(line) == 0 {
+			if len(p.spans) == 0 && p.malformed == nil {
+				continue
+			}
+
+			if err = p.malformed; err != nil {
+				p.reset()
+
+				return nil, err
+			}
+
+			return p.message(), nil
+		}
+
+		if len(p.spans) == 0 && bytes.Equal(line, responseFollows) {
+			p.output.follows = true
+		}
+
+		p.addHeader(line)
+	}
+}
+
+// readLine returns the line without the line terminator, the slice is valid until the next call.
+func (p *Parser) readLine() ([]byte, error) {
+	for {
+		chunk, err := p.r.ReadSlice('\n')
+
+		if err == nil && len(p.line) == 0 {
+			return trimEOL(chunk), nil
+		}
+
+		p.line = append(p.line, chunk...)
+
+		if err == nil || (err == io.EOF && len(p.line) != 0) {
+			line := trimEOL(p.line)
+			p.line = p.line[:0]
+
+			return line, nil
+		}
+
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+}
+
+func (p *Parser) addHeader(line []byte) {
+	key, value, ok := splitHeader(line)
+	if !ok && p.Strict {
+		if p.malformed == nil {
+			p.malformed = malformedHeader(line)
+		}
+
+		return
+	}
+
+	s := span{keyStart: len(p.raw)}
+
+	p.raw = append(p.raw, key...)
+	s.keyEnd = len(p.raw)
+	s.key = commonKeys[string(key)]
+
+	s.valueStart = len(p.raw)
+	p.raw = append(p.raw, value...)
+	s.valueEnd = len(p.raw)
+
+	p.spans = append(p.spans, s)
+}
+
+func (p *Parser) message() Message {
+	raw := string(p.raw)
+
+	msg := *messagePool.Get().(*Message) //nolint:forcetypeassert // pool holds *Message only
+
+	for _, s := range p.spans {
+		key := s.key
+		if key == "" {
+			key = raw[s.keyStart:s.keyEnd]
+		}
+
+		msg = append(msg, Header{Key: key, Value: raw[s.valueStart:s.valueEnd]})
+	}
+
+	for _, l := range p.output.lines {
+		msg = append(msg, Header{Key: "Output", Value: l})
+	}
+
+	p.reset()
+
+	return msg
+}
+
+func (p *Parser) reset() {
+	p.raw = p.raw[:0]
+	p.spans = p.spans[:0]
+	p.output = commandOutput{}
+	p.malformed = nil
+}
+
+func trimEOL(line []byte) []byte {
+	line = bytes.TrimSuffix(line, lineTerm)
+
+	return bytes.TrimSuffix(line, carriageReturn)
+}
@@ -0,0 +1,131 @@
+package amiclient
+
+import "context"
+
+const listBufferSize = 100
+
+// pendingAction waits for the messages of an action. ch is never closed, the reader may
+// still be sending to it: failed is closed instead and err tells why.
+type pendingAction struct {
+	ch     chan Message
+	done   chan struct{}
+	failed chan struct{}
+	list   bool
+	// sub gets the list messages in the event stream order, ch only the response and the completion.
+	sub *Subscription
+	err error
+}
+
+func (c *Client) registerPending(id string, list bool, sub *Subscription) (*pendingAction, error) {
+	if c.stoppedReader() {
+		return nil, ErrClientClosed
+	}
+
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	if _, ok := c.pending[id]; ok {
+		return nil, ErrDuplicateActionID
+	}
+
+	p := &pendingAction{
+		ch:     make(chan Message, 1),
+		done:   make(chan struct{}),
+		failed: make(chan struct{}),
+		list:   list,
+		sub:    sub,
+	}
+
+	if list {
+		p.ch = make(chan Message, listBufferSize)
+	}
+
+	c.pending[id] = p
+
+	return p, nil
+}
+
+func (c *Client) unregisterPending(id string) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	p, ok := c.pending[id]
+	if !ok {
+		return
+	}
+
+	close(p.done)
+	delete(c.pending, id)
+}
+
+// dispatchPending routes a response to the Do call waiting for its ActionID.
+// Events are consumed only by list actions, otherwise they stay in the event stream.
+func (c *Client) dispatchPending(msg Message) bool {
+	id, ok := msg.Lookup("ActionID")
+	if !ok {
+		return false
+	}
+
+	c.pendingMu.Lock()
+	p, ok := c.pending[id]
+	c.pendingMu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	if msg.Has("Event") && !p.list {
+		return false
+	}
+
+	if p.sub != nil {
+		p.sub.sink.deliver(msg)
+
+		if !isListComplete(msg) {
+			return true
+		}
+	}
+
+	select {
+	case p.ch <- msg:
+	case <-p.done:
+	case <-p.failed:
+	}
+
+	return true
+}
+
+// next returns the next message of the action, a message received before a failure comes first.
+func (p *pendingAction) next(ctx context.Context) (Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case msg := <-p.ch:
+		return msg, nil
+	case <-p.failed:
+		select {
+		case msg := <-p.ch:
+			return msg, nil
+		default:
+			return nil, p.err
+		}
+	}
+}
+
+// failPending is called by the reader on exit, waiting Do calls get ErrConnectionLost
+// or ErrClientClosed when the client is closing.
+func (c *Client) failPending() {
+	err := ErrConnectionLost
+	if c.isClosing() {
+		err = ErrClientClosed
+	}
+
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	for id, p := range c.pending {
+		p.err = err
+		close(p.failed)
+		delete(c.pending, id)
+	}
+}
@@ -0,0 +1,186 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Metrics struct {
+	mu sync.Mutex
+	// stored are the members of the queues with gauges.
+	stored map[string]map[string]struct{}
+
+	members          *prometheus.GaugeVec
+	membersPaused    *prometheus.GaugeVec
+	membersAvailable *prometheus.GaugeVec
+	memberStatus     *prometheus.GaugeVec
+	memberPaused     *prometheus.GaugeVec
+	memberPenalty    *prometheus.GaugeVec
+	callers          *prometheus.GaugeVec
+	longestWait      *prometheus.GaugeVec
+	holdTime         *prometheus.GaugeVec
+	completed        *prometheus.GaugeVec
+	abandoned        *prometheus.GaugeVec
+}
+
+func newMetrics(service string) *Metrics {
+	m := &Metrics{
+		stored: make(map[string]map[string]struct{}),
+		members: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_members",
+			},
+			[]string{"queue"},
+		),
+		membersPaused: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_members_paused",
+			},
+			[]string{"queue"},
+		),
+		membersAvailable: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_members_available",
+			},
+			[]string{"queue"},
+		),
+		memberStatus: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_member_status",
+				Help:      "Device state of the member, see ast_device_state.",
+			},
+			[]string{"queue", "member"},
+		),
+		memberPaused: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_member_paused",
+			},
+			[]string{"queue", "member"},
+		),
+		memberPenalty: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_member_penalty",
+			},
+			[]string{"queue", "member"},
+		),
+		callers: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_callers",
+			},
+			[]string{"queue"},
+		),
+		longestWait: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_longest_wait_seconds",
+			},
+			[]string{"queue"},
+		),
+		holdTime: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_holdtime_seconds",
+			},
+			[]string{"queue"},
+		),
+		completed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_completed",
+			},
+			[]string{"queue"},
+		),
+		abandoned: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: service,
+				Name:      "ami_queue_abandoned",
+			},
+			[]string{"queue"},
+		),
+	}
+
+	return m
+}
+
+func (m *Metrics) getMetrics() []prometheus.Collector {
+	collectors := []prometheus.Collector{
+		m.members,
+		m.membersPaused,
+		m.membersAvailable,
+		m.memberStatus,
+		m.memberPaused,
+		m.memberPenalty,
+		m.callers,
+		m.longestWait,
+		m.holdTime,
+		m.completed,
+		m.abandoned,
+	}
+
+	return collectors
+}
+
+// StoreQueue sets the gauges of the queue from the snapshot, the gauges of removed members are deleted.
+func (m *Metrics) StoreQueue(q Queue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.members.WithLabelValues(q.Name).Set(float64(len(q.Members)))
+	m.membersPaused.WithLabelValues(q.Name).Set(float64(q.Paused()))
+	m.membersAvailable.WithLabelValues(q.Name).Set(float64(q.Available()))
+	m.callers.WithLabelValues(q.Name).Set(float64(len(q.Callers)))
+	m.longestWait.WithLabelValues(q.Name).Set(q.LongestWait().Seconds())
+	m.holdTime.WithLabelValues(q.Name).Set(q.HoldTime.Seconds())
+	m.completed.WithLabelValues(q.Name).Set(float64(q.Completed))
+	m.abandoned.WithLabelValues(q.Name).Set(float64(q.Abandoned))
+
+	stored := make(map[string]struct{}, len(q.Members))
+
+	for _, member := range q.Members {
+		paused := 0.0
+		if member.Paused {
+			paused = 1
+		}
+
+		m.memberStatus.WithLabelValues(q.Name, member.Interface).Set(float64(member.Status))
+		m.memberPaused.WithLabelValues(q.Name, member.Interface).Set(paused)
+		m.memberPenalty.WithLabelValues(q.Name, member.Interface).Set(float64(member.Penalty))
+
+		stored[member.Interface] = struct{}{}
+	}
+
+	for iface := range m.stored[q.Name] {
+		if _, ok := stored[iface]; !ok {
+			m.memberStatus.DeleteLabelValues(q.Name, iface)
+			m.memberPaused.DeleteLabelValues(q.Name, iface)
+			m.memberPenalty.DeleteLabelValues(q.Name, iface)
+		}
+	}
+
+	m.stored[q.Name] = stored
+}
+
+// DeleteQueue removes the gauges of a queue missing after a bootstrap.
+func (m *Metrics) DeleteQueue(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := prometheus.Labels{"queue": name}
+
+	for _, g := range []*prometheus.GaugeVec{
+		m.members, m.membersPaused, m.membersAvailable, m.memberStatus, m.memberPaused,
+		m.memberPenalty, m.callers, m.longestWait, m.holdTime, m.completed, m.abandoned,
+	} {
+		g.DeletePartialMatch(labels)
+	}
+
+	delete(m.stored, name)
+}
@@ -0,0 +1,210 @@
+// Package queue keeps the app_queue queues, their members and waiting callers
+// of an Asterisk server up to date from the AMI event stream.
+package queue
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient/events"
+)
+
+// Device states of a member (Status), see ast_device_state.
+const (
+	DeviceUnknown = iota
+	DeviceNotInUse
+	DeviceInUse
+	DeviceBusy
+	DeviceInvalid
+	DeviceUnavailable
+	DeviceRinging
+	DeviceRingInUse
+	DeviceOnHold
+)
+
+type Member struct {
+	// Interface identifies the member in the queue, for example PJSIP/101 or Local/101@agents.
+	Interface      string
+	Name           string
+	StateInterface string
+	Membership     string
+	Penalty        int
+	CallsTaken     int
+	LastCall       time.Time
+	InCall         bool
+	Status         int
+	Paused         bool
+	PausedReason   string
+}
+
+// Available reports whether the member can take a call.
+func (m Member) Available() bool {
+	return !m.Paused && !m.InCall && m.Status == DeviceNotInUse
+}
+
+// Caller is a channel waiting in a queue.
+type Caller struct {
+	Uniqueid     string
+	Channel      string
+	CallerIDNum  string
+	CallerIDName string
+	Position     int
+	Joined       time.Time
+}
+
+func (c Caller) Wait() time.Duration {
+	return time.Since(c.Joined)
+}
+
+// Queue is the last known state of a queue. Members are ordered by interface and
+// callers by position. Completed, Abandoned and the averages are the Asterisk counters
+// loaded by the bootstrap and updated from the events since.
+type Queue struct {
+	Name      string
+	Strategy  string
+	Max       int
+	Members   []Member
+	Callers   []Caller
+	Completed int
+	Abandoned int
+	HoldTime  time.Duration
+	TalkTime  time.Duration
+	Updated   time.Time
+}
+
+func (q Queue) Member(iface string) (Member, bool) {
+	for _, m := range q.Members {
+		if m.Interface == iface {
+			return m, true
+		}
+	}
+
+	return Member{}, false
+}
+
+func (q Queue) Paused() int {
+	n := 0
+
+	for _, m := range q.Members {
+		if m.Paused {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (q Queue) Available() int {
+	n := 0
+
+	for _, m := range q.Members {
+		if m.Available() {
+			n++
+		}
+	}
+
+	return n
+}
+
+// LongestWait is the longest wait time among the callers.
+func (q Queue) LongestWait() time.Duration {
+	var res time.Duration
+
+	for _, c := range q.Callers {
+		if wait := c.Wait(); wait > res {
+			res = wait
+		}
+	}
+
+	return res
+}
+
+// Change describes a queue change, Event is the event caused it, nil for a bootstrap.
+type Change struct {
+	Queue Queue
+	Event events.Event
+}
+
+type queue struct {
+	info    Queue
+	members map[string]*Member
+	callers map[string]*Caller
+}
+
+func newQueue(name string) *queue {
+	return &queue{
+		info:    Queue{Name: name},
+		members: make(map[string]*Member),
+		callers: make(map[string]*Caller),
+	}
+}
+
+func (q *queue) snapshot() Queue {
+	res := q.info
+
+	res.Members = make([]Member, 0, len(q.members))
+	for _, m := range q.members {
+		res.Members = append(res.Members, *m)
+	}
+
+	res.Callers = make([]Caller, 0, len(q.callers))
+	for _, c := range q.callers {
+		res.Callers = append(res.Callers, *c)
+	}
+
+	sort.Slice(res.Members, func(i, j int) bool { return res.Members[i].Interface < res.Members[j].Interface })
+	sort.Slice(res.Callers, func(i, j int) bool { return res.Callers[i].Position < res.Callers[j].Position })
+
+	return res
+}
+
+func (q *queue) member(iface string) *Member {
+	m, ok := q.members[iface]
+	if !ok {
+		m = &Member{Interface: iface}
+		q.members[iface] = m
+	}
+
+	return m
+}
+
+// leave removes the caller and moves up the callers behind it.
+func (q *queue) leave(uniqueid string) {
+	c, ok := q.callers[uniqueid]
+	if !ok {
+		return
+	}
+
+	delete(q.callers, uniqueid)
+
+	for _, other := range q.callers {
+		if other.Position > c.Position {
+			other.Position--
+		}
+	}
+}
+
+func memberStatus(m *Member, e *events.QueueMemberStatus) {
+	m.Name = e.MemberName
+	m.StateInterface = e.StateInterface
+	m.Membership = e.Membership
+	m.Penalty = e.Penalty
+	m.CallsTaken = e.CallsTaken
+	m.LastCall = unixTime(e.LastCall)
+	m.InCall = e.InCall
+	m.Status = e.Status
+	m.Paused = e.Paused
+	m.PausedReason = e.PausedReason
+}
+
+func unixTime(sec int64) time.Time {
+	if sec <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(sec, 0)
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,480 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arten331/observability/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/events"
+)
+
+const (
+	bootstrapTimeout = 30 * time.Second
+	metricsInterval  = 5 * time.Second
+)
+
+//nolint:gochecknoglobals // subscription filter
+var queueEvents = []string{
+	"QueueMemberStatus", "QueueMemberAdded", "QueueMemberRemoved", "QueueMemberPause",
+	"QueueCallerJoin", "QueueCallerLeave", "QueueCallerAbandon", "AgentConnect", "AgentComplete",
+}
+
+// Tracker is a concurrency safe model of the queues of the server. It follows the queue
+// events of the client and reloads the queues with QueueSummary and QueueStatus on every
+// (re)connect, the gauges of Metrics are kept in sync with the model.
+type Tracker struct {
+	client  *amiclient.Client
+	metrics *Metrics
+
+	mu     sync.RWMutex
+	queues map[string]*queue
+	// merge follows the QueueSummary and QueueStatus lists of the last bootstrap until they are done.
+	merge *amiclient.ListMerge
+
+	listenersMu sync.Mutex
+	listeners   map[uint64]func(Change)
+	listenerSeq uint64
+
+	sub          *amiclient.Subscription
+	unregister   func()
+	cancel       context.CancelFunc
+	bootstrapped chan struct{}
+	once         sync.Once
+}
+
+// NewTracker creates a tracker, service is the namespace of the metrics.
+func NewTracker(client *amiclient.Client, service string) *Tracker {
+	return &Tracker{
+		client:       client,
+		metrics:      newMetrics(service),
+		queues:       make(map[string]*queue),
+		listeners:    make(map[uint64]func(Change)),
+		bootstrapped: make(chan struct{}),
+	}
+}
+
+// Start subscribes to the queue events and bootstraps the model when the client
+// is connected, further bootstraps run after every reconnect until Stop or ctx is done.
+func (t *Tracker) Start(ctx context.Context) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+
+	sub, err := t.client.SubscribeFunc(amiclient.Filter{Events: queueEvents}, t.handleMessage)
+	if err != nil {
+		t.cancel()
+
+		return err
+	}
+
+	t.sub = sub
+
+	t.unregister = t.client.OnStateChange(func(state amiclient.ConnectionState) {
+		if state == amiclient.StateConnected {
+			go t.bootstrap(ctx)
+		}
+	})
+
+	if t.client.State() == amiclient.StateConnected {
+		go t.bootstrap(ctx)
+	}
+
+	go t.refreshMetrics(ctx)
+
+	return nil
+}
+
+func (t *Tracker) Stop() {
+	if t.unregister != nil {
+		t.unregister()
+	}
+
+	if t.sub != nil {
+		t.sub.Unsubscribe()
+	}
+
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// Bootstrapped is closed after the first successful bootstrap.
+func (t *Tracker) Bootstrapped() <-chan struct{} {
+	return t.bootstrapped
+}
+
+// OnChange registers a listener called on every queue change in the order of the events,
+// the returned function removes it. Listeners are called synchronously and must not block.
+func (t *Tracker) OnChange(fn func(Change)) func() {
+	t.listenersMu.Lock()
+	defer t.listenersMu.Unlock()
+
+	t.listenerSeq++
+	id := t.listenerSeq
+	t.listeners[id] = fn
+
+	return func() {
+		t.listenersMu.Lock()
+		defer t.listenersMu.Unlock()
+
+		delete(t.listeners, id)
+	}
+}
+
+func (t *Tracker) GetMetrics() []prometheus.Collector {
+	return t.metrics.getMetrics()
+}
+
+func (t *Tracker) Queue(name string) (Queue, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	q, ok := t.queues[name]
+	if !ok {
+		return Queue{}, false
+	}
+
+	return q.snapshot(), true
+}
+
+// Queues returns the queues ordered by name.
+func (t *Tracker) Queues() []Queue {
+	t.mu.RLock()
+
+	res := make([]Queue, 0, len(t.queues))
+	for _, q := range t.queues {
+		res = append(res, q.snapshot())
+	}
+
+	t.mu.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+	return res
+}
+
+func (t *Tracker) handleMessage(msg amiclient.Message) {
+	if t.handleList(msg) {
+		return
+	}
+
+	e, err := events.Decode(msg)
+	if e == nil {
+		logger.L().Debug("queue tracker skipped event", zap.Error(err))
+
+		return
+	}
+
+	if err != nil {
+		logger.L().Debug("queue tracker event decoded partially", zap.Error(err))
+	}
+
+	t.Apply(e)
+}
+
+// Apply updates the model with the event, unrelated events are ignored.
+func (t *Tracker) Apply(e events.Event) {
+	now := time.Now()
+
+	t.mu.Lock()
+
+	q := t.apply(e, now)
+	if q == nil {
+		t.mu.Unlock()
+
+		return
+	}
+
+	q.info.Updated = now
+	change := Change{Queue: q.snapshot(), Event: e}
+
+	// under mu, so an older snapshot never overwrites the gauges
+	t.metrics.StoreQueue(change.Queue)
+
+	t.mu.Unlock()
+
+	t.notify(change)
+}
+
+//nolint:cyclop // one case per event
+func (t *Tracker) apply(e events.Event, now time.Time) *queue {
+	switch e := e.(type) {
+	case *events.QueueMemberStatus:
+		q := t.queue(e.Queue)
+		memberStatus(t.member(q, e.Interface), e)
+
+		return q
+	case *events.QueueMemberAdded:
+		q := t.queue(e.Queue)
+		memberStatus(t.member(q, e.Interface), (*events.QueueMemberStatus)(e))
+
+		return q
+	case *events.QueueMemberPause:
+		q := t.queue(e.Queue)
+		memberStatus(t.member(q, e.Interface), (*events.QueueMemberStatus)(e))
+
+		return q
+	case *events.QueueMemberRemoved:
+		q := t.queue(e.Queue)
+		t.seen(e.Queue, e.Interface)
+		delete(q.members, e.Interface)
+
+		return q
+	case *events.QueueCallerJoin:
+		q := t.queue(e.Queue)
+		t.seen(e.Queue, e.Uniqueid)
+		q.callers[e.Uniqueid] = &Caller{
+			Uniqueid:     e.Uniqueid,
+			Channel:      e.Channel,
+			CallerIDNum:  e.CallerIDNum,
+			CallerIDName: e.CallerIDName,
+			Position:     e.Position,
+			Joined:       now,
+		}
+
+		return q
+	case *events.QueueCallerLeave:
+		q := t.queue(e.Queue)
+		t.seen(e.Queue, e.Uniqueid)
+		q.leave(e.Uniqueid)
+
+		return q
+	case *events.QueueCallerAbandon:
+		q := t.queue(e.Queue)
+		q.info.Abandoned++
+
+		return q
+	case *events.AgentConnect:
+		// Asterisk keeps the averages as (old*3 + new) / 4.
+		q := t.queue(e.Queue)
+		q.info.HoldTime = (q.info.HoldTime*3 + seconds(e.HoldTime)) / 4
+		t.member(q, e.Interface).InCall = true
+
+		return q
+	case *events.AgentComplete:
+		q := t.queue(e.Queue)
+		q.info.TalkTime = (q.info.TalkTime*3 + seconds(e.TalkTime)) / 4
+		q.info.Completed++
+		t.member(q, e.Interface).InCall = false
+
+		return q
+	}
+
+	return nil
+}
+
+// queue returns the queue creating it when needed, must be called under mu.
+func (t *Tracker) queue(name string) *queue {
+	t.seen(name)
+
+	q, ok := t.queues[name]
+	if !ok {
+		q = newQueue(name)
+		t.queues[name] = q
+	}
+
+	return q
+}
+
+// member returns the member of the queue creating it when needed, must be called under mu.
+func (t *Tracker) member(q *queue, iface string) *Member {
+	t.seen(q.info.Name, iface)
+
+	return q.member(iface)
+}
+
+// seen keeps the queue, or its member or caller, out of the stale ones of the running bootstrap.
+// Must be called under mu.
+func (t *Tracker) seen(keys ...string) {
+	if t.merge != nil {
+		t.merge.Seen(strings.Join(keys, "\x00"))
+	}
+}
+
+func (t *Tracker) stale(keys ...string) bool {
+	return t.merge.Stale(strings.Join(keys, "\x00"))
+}
+
+func (t *Tracker) notify(change Change) {
+	t.listenersMu.Lock()
+
+	listeners := make([]func(Change), 0, len(t.listeners))
+	for _, fn := range t.listeners {
+		listeners = append(listeners, fn)
+	}
+
+	t.listenersMu.Unlock()
+
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+// bootstrap loads the queues. The lists are delivered with the events and applied in the order
+// Asterisk sent them, see handleList.
+func (t *Tracker) bootstrap(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	defer cancel()
+
+	summary, status := amiclient.NewAction("QueueSummary"), amiclient.NewAction("QueueStatus")
+	summary.Add("ActionID", t.client.NewActionID())
+	status.Add("ActionID", t.client.NewActionID())
+
+	merge := amiclient.NewListMerge(summary.Get("ActionID"), status.Get("ActionID"))
+
+	t.mu.Lock()
+	t.merge = merge
+	t.mu.Unlock()
+
+	for _, action := range []amiclient.Action{summary, status} {
+		err := t.sub.DoList(ctx, action)
+		if err != nil {
+			logger.L().Warn("queue tracker bootstrap failed", zap.Error(err))
+
+			t.mu.Lock()
+			if t.merge == merge {
+				t.merge = nil
+			}
+			t.mu.Unlock()
+
+			return
+		}
+	}
+}
+
+// handleList applies the messages of the bootstrap lists, it returns false for the events.
+// When the lists are done the queues, members and callers they missed are removed unless
+// an event read after the first list response changed them.
+func (t *Tracker) handleList(msg amiclient.Message) bool {
+	var changes []Change
+
+	t.mu.Lock()
+
+	if t.merge == nil {
+		t.mu.Unlock()
+
+		return false
+	}
+
+	switch t.merge.Read(msg) {
+	case amiclient.ListEvent:
+		t.mu.Unlock()
+
+		return false
+	case amiclient.ListItem:
+		t.load(msg, time.Now())
+	case amiclient.ListDone:
+		if t.merge.Complete() {
+			changes = t.removeStale()
+			t.once.Do(func() { close(t.bootstrapped) })
+		}
+
+		t.merge = nil
+	case amiclient.ListResponse, amiclient.ListComplete:
+	}
+
+	t.mu.Unlock()
+
+	for _, change := range changes {
+		t.notify(change)
+	}
+
+	return true
+}
+
+// load applies a list item, must be called under mu.
+func (t *Tracker) load(item amiclient.Message, now time.Time) {
+	e, _ := events.Decode(item)
+
+	switch e := e.(type) {
+	case *events.QueueSummary:
+		q := t.queue(e.Queue)
+		q.info.HoldTime, q.info.TalkTime = seconds(e.HoldTime), seconds(e.TalkTime)
+		q.info.Updated = now
+	case *events.QueueParams:
+		q := t.queue(e.Queue)
+		q.info.Strategy, q.info.Max = e.Strategy, e.Max
+		q.info.Completed, q.info.Abandoned = e.Completed, e.Abandoned
+		q.info.HoldTime, q.info.TalkTime = seconds(e.Holdtime), seconds(e.TalkTime)
+		q.info.Updated = now
+	case *events.QueueMember:
+		q := t.queue(e.Queue)
+		m := t.member(q, e.Location)
+		m.Name, m.StateInterface, m.Membership = e.Name, e.StateInterface, e.Membership
+		m.Penalty, m.CallsTaken, m.LastCall = e.Penalty, e.CallsTaken, unixTime(e.LastCall)
+		m.InCall, m.Status, m.Paused, m.PausedReason = e.InCall, e.Status, e.Paused, e.PausedReason
+		q.info.Updated = now
+	case *events.QueueEntry:
+		q := t.queue(e.Queue)
+		t.seen(e.Queue, e.Uniqueid)
+		q.callers[e.Uniqueid] = &Caller{
+			Uniqueid:     e.Uniqueid,
+			Channel:      e.Channel,
+			CallerIDNum:  e.CallerIDNum,
+			CallerIDName: e.CallerIDName,
+			Position:     e.Position,
+			Joined:       now.Add(-seconds(e.Wait)),
+		}
+		q.info.Updated = now
+	}
+}
+
+// removeStale removes what the bootstrap missed and returns a change for every queue left,
+// must be called under mu.
+func (t *Tracker) removeStale() []Change {
+	changes := make([]Change, 0, len(t.queues))
+
+	for name, q := range t.queues {
+		if t.stale(name) {
+			delete(t.queues, name)
+			t.metrics.DeleteQueue(name)
+
+			continue
+		}
+
+		for iface := range q.members {
+			if t.stale(name, iface) {
+				delete(q.members, iface)
+			}
+		}
+
+		for uniqueid := range q.callers {
+			if t.stale(name, uniqueid) {
+				delete(q.callers, uniqueid)
+			}
+		}
+
+		change := Change{Queue: q.snapshot()}
+		t.metrics.StoreQueue(change.Queue)
+
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// refreshMetrics updates the wait time gauges, they change without events.
+func (t *Tracker) refreshMetrics(ctx context.Context) {
+	ticker := time.NewTicker(metricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.mu.RLock()
+
+			for _, q := range t.queues {
+				t.metrics.StoreQueue(q.snapshot())
+			}
+
+			t.mu.RUnlock()
+		}
+	}
+}
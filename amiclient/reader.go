@@ -4,86 +4,148 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"io"
-	"strings"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/Arten331/observability/logger"
+	"go.uber.org/zap"
 )
 
+// errConnectionReplaced is returned by a reader whose connection was replaced by a new one,
+// the state and the pending actions belong to the new connection then.
+var errConnectionReplaced = errors.New("connection replaced")
+
 func (c *Client) runReader(ctx context.Context) {
+	err := c.readLoop(ctx)
+	if err == nil || errors.Is(err, errConnectionReplaced) {
+		return
+	}
+
+	c.setState(StateDisconnected)
+	c.sendErr(err)
+}
+
+// sendErr reports the error on ErrChan unless an earlier one is still unread.
+func (c *Client) sendErr(err error) {
+	select {
+	case c.errChan <- err:
+	default:
+	}
+}
+
+// readLoop reads messages until the connection fails, nil is returned when the client is stopped.
+func (c *Client) readLoop(ctx context.Context) (err error) {
+	c.connMu.Lock()
+	conn, reader, gen := c.conn, c.reader, c.connGen
+	c.readerGen = gen
+	c.connMu.Unlock()
+
+	parser := NewParser(reader)
+	parser.Strict = c.settings.StrictParsing
+	stop, dead := make(chan struct{}), make(chan struct{})
+
 	defer func() {
-		_ = c.conn.Close()
+		close(stop)
+		_ = conn.Close()
+
+		if !c.currentConnection(gen) {
+			if err != nil {
+				err = fmt.Errorf("%w: %w", errConnectionReplaced, err)
+			}
+
+			return
+		}
+
+		c.failPending()
 	}()
 
-	reader := bufio.NewReader(c.conn)
+	if c.settings.PingInterval > 0 {
+		go c.keepalive(ctx, conn, stop, dead)
+	}
 
 	for {
 		select {
 		case <-c.stopReader:
-			return
+			return nil
 		case <-ctx.Done():
-			return
+			return nil
 		default:
 			if c.settings.ReadTimeOut != 0 {
-				_ = c.conn.SetReadDeadline(time.Now().Add(c.settings.ReadTimeOut))
+				_ = conn.SetReadDeadline(time.Now().Add(c.settings.ReadTimeOut))
 			}
 
-			msg, err := ReadMessage(reader)
+			msg, err := parser.Next()
 
 			c.metrics.StoreReceivedMessage()
 
-			if err != nil {
-				c.errChan <- err
+			if errors.Is(err, ErrMalformedHeader) {
+				logger.L().Warn("AMI message dropped", zap.Error(err))
 
-				return
+				continue
 			}
 
-			if len(msg) == 0 {
-				continue
+			if err != nil {
+				if c.isClosing() {
+					return nil
+				}
+
+				select {
+				case <-dead:
+					return ErrPingTimeout
+				default:
+					return err
+				}
 			}
 
-			c.msgChan <- msg
+			c.settings.Recorder.Record(DirectionIn, msg)
+			c.handleMessage(msg)
 		}
 	}
 }
 
+func (c *Client) handleMessage(msg Message) {
+	if len(msg) == 0 || c.dispatchPending(msg) {
+		return
+	}
+
+	if !c.dispatch(msg) {
+		releaseMessage(msg)
+	}
+}
+
+// ReadMessage reads one message, see Parser. Use a Parser to read a stream of messages,
+// it keeps a message interrupted by a read timeout and reuses its buffers.
 func ReadMessage(r *bufio.Reader) (Message, error) {
-	var (
-		buf      bytes.Buffer
-		isPrefix bool
-		err      error
-		line     []byte
-	)
+	return NewParser(r).Next()
+}
 
-	for {
-		line, isPrefix, err = r.ReadLine()
-		if err == io.EOF /*|| isPrefix */ {
-			continue
-		}
+// commandOutput collects the free-form body of a "Response: Follows" message (Command action
+// on Asterisk 13 and older). The body ends with --END COMMAND--, blank lines inside it are output.
+type commandOutput struct {
+	follows bool
+	lines   []string
+}
 
-		if err != nil {
-			if strings.Contains(err.Error(), "i/o timeout") {
-				err = nil
-			} else {
-				return nil, err
-			}
-		}
+func (o *commandOutput) consume(line []byte) bool {
+	if !o.follows {
+		return false
+	}
 
-		if line == nil || len(line) <= 1 {
-			break
-		}
+	if len(o.lines) == 0 && (bytes.HasPrefix(line, privilegeHeader) || bytes.HasPrefix(line, actionIDHeader)) {
+		return false
+	}
 
-		buf.Write(line)
+	if bytes.HasSuffix(line, endCommand) {
+		o.follows = false
+		line = bytes.TrimSuffix(line, endCommand)
 
-		if !isPrefix {
-			buf.Write([]byte{'\n'})
+		if len(line) == 0 {
+			return true
 		}
-
-		//if r.Buffered() == 0 {
-		//	break
-		//}
 	}
 
-	msg := ParseMessage(buf)
+	o.lines = append(o.lines, string(line))
 
-	return msg, err
+	return true
 }
@@ -0,0 +1,190 @@
+package amiclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recordPrefix = "# "
+	maskedSecret = "********"
+)
+
+var ErrInvalidRecord = errors.New("invalid session record")
+
+// Direction tags a recorded message as received from or sent to Asterisk.
+type Direction string
+
+const (
+	DirectionIn  Direction = "in"
+	DirectionOut Direction = "out"
+)
+
+// Record is a message of a recorded session.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Message   Message
+}
+
+// Recorder writes the traffic of a Client (Settings.Recorder) to a session file. Every message
+// is preceded by a "# <RFC3339 time> <in|out>" line and written in the wire format.
+// The messages are recorded as parsed by the client, not the raw bytes: the banner, the
+// line terminators and the malformed lines dropped by the parser are not in the session.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Record appends the message, the first write error is kept and returned by Err.
+// The Login secret is masked.
+func (r *Recorder) Record(direction Direction, msg Message) {
+	r.RecordAt(time.Now(), direction, msg)
+}
+
+// RecordAt is Record with the given time.
+func (r *Recorder) RecordAt(t time.Time, direction Direction, msg Message) {
+	if r == nil || len(msg) == 0 {
+		return
+	}
+
+	if msg.Has("Secret") {
+		msg = msg.Clone()
+		msg.Set("Secret", maskedSecret)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	_, r.err = fmt.Fprintf(r.w, "%s%s %s\n%s", recordPrefix, t.UTC().Format(time.RFC3339Nano), direction, msg.SerializeWire())
+}
+
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// ReadRecords reads a session written by Recorder. The message of every record is parsed on
+// its own, a broken message can not swallow the records after it.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var (
+		records []Record
+		body    bytes.Buffer
+	)
+
+	// parse parses the body of the last record
+	parse := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+
+		msg, err := NewParser(bufio.NewReader(&body)).Next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		records[len(records)-1].Message = msg
+		body.Reset()
+
+		return nil
+	}
+
+	reader := bufio.NewReader(r)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return records, err
+		}
+
+		record, recordErr := parseRecordLine(strings.TrimSpace(line))
+
+		switch {
+		case recordErr == nil:
+			if err := parse(); err != nil {
+				return records, err
+			}
+
+			records = append(records, record)
+		case len(records) != 0:
+			body.WriteString(line)
+		case strings.TrimSpace(line) != "":
+			return records, recordErr
+		}
+
+		if err == io.EOF {
+			return records, parse()
+		}
+	}
+}
+
+func parseRecordLine(line string) (Record, error) {
+	fields := strings.Fields(strings.TrimPrefix(line, recordPrefix))
+	if !strings.HasPrefix(line, recordPrefix) || len(fields) != 2 {
+		return Record{}, fmt.Errorf("%w: %q", ErrInvalidRecord, line)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return Record{}, fmt.Errorf("%w: %w", ErrInvalidRecord, err)
+	}
+
+	direction := Direction(fields[1])
+	if direction != DirectionIn && direction != DirectionOut {
+		return Record{}, fmt.Errorf("%w: unknown direction %q", ErrInvalidRecord, direction)
+	}
+
+	return Record{Time: t, Direction: direction}, nil
+}
+
+// Replay calls fn for the records keeping the recorded intervals divided by speed,
+// speed 1 is the original pace, zero or negative speed replays without delays.
+func Replay(ctx context.Context, records []Record, speed float64, fn func(Record)) error {
+	for i, record := range records {
+		if i > 0 && speed > 0 {
+			delay := time.Duration(float64(record.Time.Sub(records[i-1].Time)) / speed)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fn(record)
+	}
+
+	return nil
+}
+
+// Replay feeds the received messages of the session to the dispatcher as if they were
+// read from the connection, MsgChan and the subscriptions get them. Sent actions are skipped.
+func (c *Client) Replay(ctx context.Context, records []Record, speed float64) error {
+	return Replay(ctx, records, speed, func(record Record) {
+		if record.Direction == DirectionIn {
+			c.handleMessage(record.Message.Clone())
+		}
+	})
+}
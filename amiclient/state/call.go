@@ -0,0 +1,689 @@
+package state
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Arten331/observability/logger"
+	"go.uber.org/zap"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/events"
+)
+
+const (
+	channelStateUp = 6
+	causeMissing   = "Channel missing after reconnect"
+)
+
+//nolint:gochecknoglobals // subscription filter
+var callEvents = []string{
+	"Newchannel", "Newstate", "Hangup", "DialBegin", "DialEnd",
+	"BridgeCreate", "BridgeEnter", "BridgeLeave", "BridgeDestroy",
+	"BlindTransfer", "AttendedTransfer", "LocalOptimizationBegin", "LocalOptimizationEnd",
+}
+
+// Leg is a channel of a call.
+type Leg struct {
+	events.ChannelSnapshot
+	// Caller is the Uniqueid of the channel dialed this leg, empty for originated and incoming legs.
+	Caller     string
+	DialString string
+	DialStatus string
+	BridgeID   string
+	Created    time.Time
+	Answered   time.Time
+	Ended      time.Time
+	Cause      events.HangupCause
+	CauseTxt   string
+}
+
+type Bridge struct {
+	events.BridgeSnapshot
+	// Channels are the Uniqueids of the channels in the bridge.
+	Channels []string
+	Created  time.Time
+}
+
+// TimelineEntry is a step of a call, Event is the AMI event name.
+type TimelineEntry struct {
+	Time     time.Time
+	Event    string
+	Uniqueid string
+	Detail   string
+}
+
+// Call is the set of legs sharing a Linkedid.
+type Call struct {
+	Linkedid string
+	Legs     []Leg
+	// Bridges are the ids of the bridges the legs entered.
+	Bridges  []string
+	Timeline []TimelineEntry
+	Start    time.Time
+	End      time.Time
+}
+
+func (c Call) Duration() time.Duration {
+	if c.End.IsZero() {
+		return time.Since(c.Start)
+	}
+
+	return c.End.Sub(c.Start)
+}
+
+type call struct {
+	linkedid string
+	legs     []*Leg
+	bridges  []string
+	timeline []TimelineEntry
+	start    time.Time
+}
+
+func (c *call) snapshot() Call {
+	res := Call{
+		Linkedid: c.linkedid,
+		Legs:     make([]Leg, 0, len(c.legs)),
+		Bridges:  append([]string(nil), c.bridges...),
+		Timeline: append([]TimelineEntry(nil), c.timeline...),
+		Start:    c.start,
+	}
+
+	for _, leg := range c.legs {
+		res.Legs = append(res.Legs, *leg)
+	}
+
+	return res
+}
+
+func (c *call) ended() bool {
+	for _, leg := range c.legs {
+		if leg.Ended.IsZero() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CallTracker correlates channels into calls by Linkedid and follows dials, bridges,
+// transfers and Local channel optimizations, a leg moves to another call when its Linkedid
+// changes. A completed call record with the timeline is passed to the OnComplete listeners
+// when the last leg of the call hangs up.
+// After a reconnect the legs missing in CoreShowChannels hang up, their Hangup was lost.
+type CallTracker struct {
+	client *amiclient.Client
+
+	mu      sync.Mutex
+	calls   map[string]*call
+	legs    map[string]*Leg
+	legCall map[string]*call
+	bridges map[string]*Bridge
+	// completed are the calls ended under mu, they are reported after it is released.
+	completed []Call
+	// merge follows the CoreShowChannels list of the last resync until it is done.
+	merge *amiclient.ListMerge
+
+	listenersMu sync.Mutex
+	listeners   map[uint64]func(Call)
+	listenerSeq uint64
+
+	sub        *amiclient.Subscription
+	unregister func()
+	cancel     context.CancelFunc
+}
+
+func NewCallTracker(client *amiclient.Client) *CallTracker {
+	return &CallTracker{
+		client:    client,
+		calls:     make(map[string]*call),
+		legs:      make(map[string]*Leg),
+		legCall:   make(map[string]*call),
+		bridges:   make(map[string]*Bridge),
+		listeners: make(map[uint64]func(Call)),
+	}
+}
+
+// Start subscribes to the call events, calls already in progress are tracked from their next event.
+// The legs are checked against CoreShowChannels after every reconnect until Stop or ctx is done.
+func (t *CallTracker) Start(ctx context.Context) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+
+	sub, err := t.client.SubscribeFunc(amiclient.Filter{Events: callEvents}, t.handleMessage)
+	if err != nil {
+		t.cancel()
+
+		return err
+	}
+
+	t.sub = sub
+
+	t.unregister = t.client.OnStateChange(func(state amiclient.ConnectionState) {
+		if state == amiclient.StateConnected {
+			go t.resync(ctx)
+		}
+	})
+
+	return nil
+}
+
+func (t *CallTracker) Stop() {
+	if t.unregister != nil {
+		t.unregister()
+	}
+
+	if t.sub != nil {
+		t.sub.Unsubscribe()
+	}
+
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// OnComplete registers a listener of completed calls, the returned function removes it.
+// Listeners are called synchronously and must not block.
+func (t *CallTracker) OnComplete(fn func(Call)) func() {
+	t.listenersMu.Lock()
+	defer t.listenersMu.Unlock()
+
+	t.listenerSeq++
+	id := t.listenerSeq
+	t.listeners[id] = fn
+
+	return func() {
+		t.listenersMu.Lock()
+		defer t.listenersMu.Unlock()
+
+		delete(t.listeners, id)
+	}
+}
+
+// Call returns the call in progress.
+func (t *CallTracker) Call(linkedid string) (Call, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.calls[linkedid]
+	if !ok {
+		return Call{}, false
+	}
+
+	return c.snapshot(), true
+}
+
+// Calls returns the calls in progress ordered by start time.
+func (t *CallTracker) Calls() []Call {
+	t.mu.Lock()
+
+	res := make([]Call, 0, len(t.calls))
+	for _, c := range t.calls {
+		res = append(res, c.snapshot())
+	}
+
+	t.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Start.Before(res[j].Start) })
+
+	return res
+}
+
+func (t *CallTracker) Bridge(id string) (Bridge, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.bridges[id]
+	if !ok {
+		return Bridge{}, false
+	}
+
+	res := *b
+	res.Channels = append([]string(nil), b.Channels...)
+
+	return res, true
+}
+
+func (t *CallTracker) handleMessage(msg amiclient.Message) {
+	if t.handleList(msg) {
+		return
+	}
+
+	e, err := events.Decode(msg)
+	if e == nil {
+		logger.L().Debug("call tracker skipped event", zap.Error(err))
+
+		return
+	}
+
+	if err != nil {
+		logger.L().Debug("call tracker event decoded partially", zap.Error(err))
+	}
+
+	t.Apply(e)
+}
+
+// Apply updates the calls with the event, unrelated events are ignored.
+func (t *CallTracker) Apply(e events.Event) {
+	now := time.Now()
+
+	t.mu.Lock()
+	t.apply(e, now)
+	completed := t.takeCompleted()
+	t.mu.Unlock()
+
+	for _, c := range completed {
+		t.notify(c)
+	}
+}
+
+// takeCompleted returns the calls ended since the last call, must be called under mu.
+func (t *CallTracker) takeCompleted() []Call {
+	completed := t.completed
+	t.completed = nil
+
+	return completed
+}
+
+func (t *CallTracker) notify(c Call) {
+	t.listenersMu.Lock()
+
+	listeners := make([]func(Call), 0, len(t.listeners))
+	for _, fn := range t.listeners {
+		listeners = append(listeners, fn)
+	}
+
+	t.listenersMu.Unlock()
+
+	for _, fn := range listeners {
+		fn(c)
+	}
+}
+
+//nolint:funlen,cyclop // one case per event
+func (t *CallTracker) apply(e events.Event, now time.Time) {
+	switch e := e.(type) {
+	case *events.Newchannel:
+		t.record(t.leg(e.ChannelSnapshot, now), now, e, "")
+	case *events.Newstate:
+		leg := t.leg(e.ChannelSnapshot, now)
+		if leg != nil && e.ChannelState == channelStateUp && leg.Answered.IsZero() {
+			leg.Answered = now
+			t.record(leg, now, e, e.ChannelStateDesc)
+		}
+	case *events.DialBegin:
+		dest := t.leg(e.Dest, now)
+		t.leg(e.ChannelSnapshot, now)
+
+		if dest == nil {
+			break
+		}
+
+		dest.Caller, dest.DialString = e.Uniqueid, e.DialString
+
+		t.record(dest, now, e, e.DialString)
+	case *events.DialEnd:
+		dest := t.leg(e.Dest, now)
+		if dest == nil {
+			break
+		}
+
+		dest.DialStatus = e.DialStatus
+
+		t.record(dest, now, e, e.DialStatus)
+	case *events.BridgeCreate:
+		t.bridge(e.BridgeSnapshot, now)
+	case *events.BridgeEnter:
+		leg := t.leg(e.ChannelSnapshot, now)
+		if leg == nil {
+			break
+		}
+
+		b := t.bridge(e.BridgeSnapshot, now)
+		b.Channels = appendUnique(b.Channels, e.Uniqueid)
+
+		leg.BridgeID = e.BridgeUniqueid
+
+		if c := t.legCall[leg.Uniqueid]; c != nil {
+			c.bridges = appendUnique(c.bridges, e.BridgeUniqueid)
+		}
+
+		detail := e.BridgeUniqueid
+		if e.SwapUniqueid != "" {
+			detail += " swap " + e.SwapUniqueid
+		}
+
+		t.record(leg, now, e, detail)
+	case *events.BridgeLeave:
+		if b, ok := t.bridges[e.BridgeUniqueid]; ok {
+			b.BridgeSnapshot = e.BridgeSnapshot
+			b.Channels = remove(b.Channels, e.Uniqueid)
+		}
+
+		leg := t.leg(e.ChannelSnapshot, now)
+		if leg == nil {
+			break
+		}
+
+		leg.BridgeID = ""
+
+		t.record(leg, now, e, e.BridgeUniqueid)
+	case *events.BridgeDestroy:
+		delete(t.bridges, e.BridgeUniqueid)
+	case *events.BlindTransfer:
+		t.record(t.legs[e.Transferer.Uniqueid], now, e, e.Extension+"@"+e.Context+" "+e.Result)
+		t.record(t.legs[e.Transferee.Uniqueid], now, e, e.Extension+"@"+e.Context+" "+e.Result)
+	case *events.AttendedTransfer:
+		for _, id := range []string{e.OrigTransferer.Uniqueid, e.SecondTransferer.Uniqueid} {
+			t.record(t.legs[id], now, e, e.DestType+" "+e.Result)
+		}
+	case *events.LocalOptimizationBegin:
+		t.record(t.legs[e.LocalOne.Uniqueid], now, e, e.LocalOne.Channel+" "+e.LocalTwo.Channel)
+	case *events.LocalOptimizationEnd:
+		detail := "failed"
+		if e.Success {
+			detail = "optimized"
+		}
+
+		t.record(t.legs[e.LocalOne.Uniqueid], now, e, detail)
+	case *events.Hangup:
+		t.hangup(e, now)
+	}
+}
+
+// leg returns the leg of the channel creating it and its call when needed, must be called under mu.
+// It returns nil for a snapshot without Uniqueid.
+func (t *CallTracker) leg(snapshot events.ChannelSnapshot, now time.Time) *Leg {
+	if snapshot.Uniqueid == "" {
+		return nil
+	}
+
+	t.seen(snapshot.Uniqueid)
+
+	leg, ok := t.legs[snapshot.Uniqueid]
+	if ok {
+		leg.ChannelSnapshot = snapshot
+		t.regroup(leg, now)
+
+		return leg
+	}
+
+	c := t.call(linkedid(snapshot), now)
+	leg = &Leg{ChannelSnapshot: snapshot, Created: now}
+
+	c.legs = append(c.legs, leg)
+	t.legs[snapshot.Uniqueid] = leg
+	t.legCall[snapshot.Uniqueid] = c
+
+	return leg
+}
+
+// call returns the call creating it when needed, must be called under mu.
+func (t *CallTracker) call(linkedid string, now time.Time) *call {
+	c, ok := t.calls[linkedid]
+	if !ok {
+		c = &call{linkedid: linkedid, start: now}
+		t.calls[linkedid] = c
+	}
+
+	return c
+}
+
+func linkedid(snapshot events.ChannelSnapshot) string {
+	if snapshot.Linkedid == "" {
+		return snapshot.Uniqueid
+	}
+
+	return snapshot.Linkedid
+}
+
+// regroup moves the leg with its timeline entries to the call of its new Linkedid, transfers and
+// Local channel optimizations link channels to another call. The call left behind is dropped when
+// it has no legs and completed when the remaining ones ended, must be called under mu.
+func (t *CallTracker) regroup(leg *Leg, now time.Time) {
+	old := t.legCall[leg.Uniqueid]
+	if old == nil || leg.Linkedid == "" || leg.Linkedid == old.linkedid {
+		return
+	}
+
+	c := t.call(leg.Linkedid, now)
+	if leg.Created.Before(c.start) {
+		c.start = leg.Created
+	}
+
+	c.legs = append(c.legs, leg)
+	t.legCall[leg.Uniqueid] = c
+
+	legs := old.legs[:0]
+
+	for _, l := range old.legs {
+		if l != leg {
+			legs = append(legs, l)
+		}
+	}
+
+	old.legs = legs
+
+	timeline := old.timeline[:0]
+
+	for _, entry := range old.timeline {
+		if entry.Uniqueid == leg.Uniqueid {
+			c.timeline = append(c.timeline, entry)
+		} else {
+			timeline = append(timeline, entry)
+		}
+	}
+
+	old.timeline = timeline
+
+	sort.SliceStable(c.timeline, func(i, j int) bool { return c.timeline[i].Time.Before(c.timeline[j].Time) })
+
+	if leg.BridgeID != "" {
+		c.bridges = appendUnique(c.bridges, leg.BridgeID)
+	}
+
+	switch {
+	case len(old.legs) == 0:
+		delete(t.calls, old.linkedid)
+	case old.ended():
+		t.finish(old, now)
+	}
+}
+
+func (t *CallTracker) bridge(snapshot events.BridgeSnapshot, now time.Time) *Bridge {
+	b, ok := t.bridges[snapshot.BridgeUniqueid]
+	if !ok {
+		b = &Bridge{Created: now}
+		t.bridges[snapshot.BridgeUniqueid] = b
+	}
+
+	b.BridgeSnapshot = snapshot
+
+	return b
+}
+
+func (t *CallTracker) record(leg *Leg, now time.Time, e events.Event, detail string) {
+	if leg == nil {
+		return
+	}
+
+	c := t.legCall[leg.Uniqueid]
+	if c == nil {
+		return
+	}
+
+	c.timeline = append(c.timeline, TimelineEntry{
+		Time:     now,
+		Event:    e.EventName(),
+		Uniqueid: leg.Uniqueid,
+		Detail:   detail,
+	})
+}
+
+func (t *CallTracker) hangup(e *events.Hangup, now time.Time) {
+	t.seen(e.Uniqueid)
+
+	leg, ok := t.legs[e.Uniqueid]
+	if !ok {
+		return
+	}
+
+	leg.ChannelSnapshot = e.ChannelSnapshot
+	t.regroup(leg, now)
+
+	leg.Ended, leg.Cause, leg.CauseTxt = now, e.Cause, e.CauseTxt
+
+	if leg.CauseTxt == "" {
+		leg.CauseTxt = e.Cause.String()
+	}
+
+	t.record(leg, now, e, leg.CauseTxt)
+
+	if c := t.legCall[e.Uniqueid]; c.ended() {
+		t.finish(c, now)
+	}
+}
+
+// finish drops the ended call with its legs and the bridges it left without channels,
+// a lost BridgeDestroy must not keep them. The call is reported by the caller of apply.
+func (t *CallTracker) finish(c *call, now time.Time) {
+	delete(t.calls, c.linkedid)
+
+	for _, l := range c.legs {
+		delete(t.legs, l.Uniqueid)
+		delete(t.legCall, l.Uniqueid)
+	}
+
+	for _, id := range c.bridges {
+		b, ok := t.bridges[id]
+		if !ok {
+			continue
+		}
+
+		for _, l := range c.legs {
+			b.Channels = remove(b.Channels, l.Uniqueid)
+		}
+
+		if len(b.Channels) == 0 {
+			delete(t.bridges, id)
+		}
+	}
+
+	res := c.snapshot()
+	res.End = now
+
+	t.completed = append(t.completed, res)
+}
+
+// seen keeps the leg out of the missing ones of the running resync, must be called under mu.
+func (t *CallTracker) seen(uniqueid string) {
+	if t.merge != nil {
+		t.merge.Seen(uniqueid)
+	}
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+
+	return append(values, value)
+}
+
+func remove(values []string, value string) []string {
+	res := values[:0]
+
+	for _, v := range values {
+		if v != value {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// resync checks the legs against CoreShowChannels, the list is delivered with the events and
+// applied in the order Asterisk sent them, see handleList.
+func (t *CallTracker) resync(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	defer cancel()
+
+	id := t.client.NewActionID()
+	merge := amiclient.NewListMerge(id)
+
+	action := amiclient.NewAction("CoreShowChannels")
+	action.Add("ActionID", id)
+
+	t.mu.Lock()
+	t.merge = merge
+	t.mu.Unlock()
+
+	err := t.sub.DoList(ctx, action)
+	if err != nil {
+		logger.L().Warn("call tracker resync failed", zap.Error(err))
+
+		t.mu.Lock()
+		if t.merge == merge {
+			t.merge = nil
+		}
+		t.mu.Unlock()
+	}
+}
+
+// handleList applies the messages of the resync list, it returns false for the events. The legs
+// missing in the list hang up unless an event read after the list response changed them.
+func (t *CallTracker) handleList(msg amiclient.Message) bool {
+	t.mu.Lock()
+
+	if t.merge == nil {
+		t.mu.Unlock()
+
+		return false
+	}
+
+	switch t.merge.Read(msg) {
+	case amiclient.ListEvent:
+		t.mu.Unlock()
+
+		return false
+	case amiclient.ListItem:
+		t.merge.Seen(msg.Get("Uniqueid"))
+	case amiclient.ListDone:
+		if t.merge.Complete() {
+			t.hangupMissing(time.Now())
+		}
+
+		t.merge = nil
+	case amiclient.ListResponse, amiclient.ListComplete:
+	}
+
+	completed := t.takeCompleted()
+
+	t.mu.Unlock()
+
+	for _, c := range completed {
+		t.notify(c)
+	}
+
+	return true
+}
+
+// hangupMissing hangs up the legs the resync missed, their Hangup was lost. Must be called under mu.
+func (t *CallTracker) hangupMissing(now time.Time) {
+	for id, leg := range t.legs {
+		if !leg.Ended.IsZero() || !t.merge.Stale(id) {
+			continue
+		}
+
+		if b, ok := t.bridges[leg.BridgeID]; ok {
+			if b.Channels = remove(b.Channels, id); len(b.Channels) == 0 {
+				delete(t.bridges, leg.BridgeID)
+			}
+		}
+
+		t.hangup(&events.Hangup{ChannelSnapshot: leg.ChannelSnapshot, CauseTxt: causeMissing}, now)
+	}
+}
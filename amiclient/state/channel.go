@@ -0,0 +1,61 @@
+// Package state keeps the channels of an Asterisk server up to date from the AMI event stream.
+package state
+
+import (
+	"time"
+
+	"github.com/Arten331/telephony/amiclient/events"
+)
+
+// Channel is the last known state of a channel.
+type Channel struct {
+	events.ChannelSnapshot
+	Application string
+	AppData     string
+	BridgeID    string
+	Variables   map[string]string
+	Created     time.Time
+	Updated     time.Time
+}
+
+func (c *Channel) clone() Channel {
+	res := *c
+
+	if c.Variables != nil {
+		res.Variables = make(map[string]string, len(c.Variables))
+		for k, v := range c.Variables {
+			res.Variables[k] = v
+		}
+	}
+
+	return res
+}
+
+type ChangeType int
+
+const (
+	ChannelAdded ChangeType = iota
+	ChannelUpdated
+	ChannelRemoved
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChannelAdded:
+		return "Added"
+	case ChannelUpdated:
+		return "Updated"
+	case ChannelRemoved:
+		return "Removed"
+	default:
+		return "Unknown"
+	}
+}
+
+// Change describes a registry change. Event is the event caused it, *events.CoreShowChannel
+// for the channels loaded by the bootstrap and nil for the channels it removed.
+type Change struct {
+	Type    ChangeType
+	Channel Channel
+	Event   events.Event
+}
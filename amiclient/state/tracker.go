@@ -0,0 +1,436 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Arten331/observability/logger"
+	"go.uber.org/zap"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/events"
+)
+
+const bootstrapTimeout = 30 * time.Second
+
+//nolint:gochecknoglobals // subscription filter
+var channelEvents = []string{
+	"Newchannel", "Newstate", "Newexten", "NewCallerid", "Rename", "VarSet", "Hangup",
+}
+
+// Tracker is a concurrency safe registry of channels keyed by Uniqueid. It follows the channel
+// events of the client and reloads the registry with CoreShowChannels on every (re)connect.
+type Tracker struct {
+	client *amiclient.Client
+
+	mu       sync.RWMutex
+	channels map[string]*Channel
+	linked   map[string]map[string]struct{}
+	// merge follows the CoreShowChannels list of the last bootstrap until it is done.
+	merge *amiclient.ListMerge
+
+	listenersMu sync.Mutex
+	listeners   map[uint64]func(Change)
+	listenerSeq uint64
+
+	sub          *amiclient.Subscription
+	unregister   func()
+	cancel       context.CancelFunc
+	bootstrapped chan struct{}
+	once         sync.Once
+}
+
+func NewTracker(client *amiclient.Client) *Tracker {
+	return &Tracker{
+		client:       client,
+		channels:     make(map[string]*Channel),
+		linked:       make(map[string]map[string]struct{}),
+		listeners:    make(map[uint64]func(Change)),
+		bootstrapped: make(chan struct{}),
+	}
+}
+
+// Start subscribes to the channel events and bootstraps the registry when the client
+// is connected, further bootstraps run after every reconnect until Stop or ctx is done.
+func (t *Tracker) Start(ctx context.Context) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+
+	sub, err := t.client.SubscribeFunc(amiclient.Filter{Events: channelEvents}, t.handleMessage)
+	if err != nil {
+		t.cancel()
+
+		return err
+	}
+
+	t.sub = sub
+
+	t.unregister = t.client.OnStateChange(func(state amiclient.ConnectionState) {
+		if state == amiclient.StateConnected {
+			go t.bootstrap(ctx)
+		}
+	})
+
+	if t.client.State() == amiclient.StateConnected {
+		go t.bootstrap(ctx)
+	}
+
+	return nil
+}
+
+func (t *Tracker) Stop() {
+	if t.unregister != nil {
+		t.unregister()
+	}
+
+	if t.sub != nil {
+		t.sub.Unsubscribe()
+	}
+
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// Bootstrapped is closed after the first successful bootstrap.
+func (t *Tracker) Bootstrapped() <-chan struct{} {
+	return t.bootstrapped
+}
+
+// OnChange registers a listener called on every registry change in the order of the events,
+// the returned function removes it. Listeners are called synchronously and must not block.
+func (t *Tracker) OnChange(fn func(Change)) func() {
+	t.listenersMu.Lock()
+	defer t.listenersMu.Unlock()
+
+	t.listenerSeq++
+	id := t.listenerSeq
+	t.listeners[id] = fn
+
+	return func() {
+		t.listenersMu.Lock()
+		defer t.listenersMu.Unlock()
+
+		delete(t.listeners, id)
+	}
+}
+
+func (t *Tracker) Channel(uniqueid string) (Channel, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	ch, ok := t.channels[uniqueid]
+	if !ok {
+		return Channel{}, false
+	}
+
+	return ch.clone(), true
+}
+
+// ChannelByName returns the channel with the name, for example SIP/peer-00000001.
+func (t *Tracker) ChannelByName(name string) (Channel, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, ch := range t.channels {
+		if ch.Channel == name {
+			return ch.clone(), true
+		}
+	}
+
+	return Channel{}, false
+}
+
+func (t *Tracker) Channels() []Channel {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	res := make([]Channel, 0, len(t.channels))
+	for _, ch := range t.channels {
+		res = append(res, ch.clone())
+	}
+
+	return res
+}
+
+// Linked returns the channels of a call, grouped by Linkedid.
+func (t *Tracker) Linked(linkedid string) []Channel {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	res := make([]Channel, 0, len(t.linked[linkedid]))
+	for id := range t.linked[linkedid] {
+		res = append(res, t.channels[id].clone())
+	}
+
+	return res
+}
+
+func (t *Tracker) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return len(t.channels)
+}
+
+func (t *Tracker) handleMessage(msg amiclient.Message) {
+	if t.handleList(msg) {
+		return
+	}
+
+	e, err := events.Decode(msg)
+	if e == nil {
+		logger.L().Debug("channel tracker skipped event", zap.Error(err))
+
+		return
+	}
+
+	if err != nil {
+		logger.L().Debug("channel tracker event decoded partially", zap.Error(err))
+	}
+
+	t.Apply(e)
+}
+
+// Apply updates the registry with the event, unrelated events are ignored.
+// It is called for the subscribed events and can be used to feed recorded events.
+func (t *Tracker) Apply(e events.Event) {
+	var (
+		change Change
+		ok     bool
+	)
+
+	switch e := e.(type) {
+	case *events.Newchannel:
+		change, ok = t.update(e, e.ChannelSnapshot, nil)
+	case *events.Newstate:
+		change, ok = t.update(e, e.ChannelSnapshot, nil)
+	case *events.NewCallerid:
+		change, ok = t.update(e, e.ChannelSnapshot, nil)
+	case *events.Newexten:
+		change, ok = t.update(e, e.ChannelSnapshot, func(ch *Channel) {
+			ch.Application, ch.AppData = e.Application, e.AppData
+		})
+	case *events.Rename:
+		change, ok = t.update(e, e.ChannelSnapshot, func(ch *Channel) {
+			ch.Channel = e.Newname
+		})
+	case *events.VarSet:
+		change, ok = t.update(e, e.ChannelSnapshot, func(ch *Channel) {
+			if ch.Variables == nil {
+				ch.Variables = make(map[string]string)
+			}
+
+			ch.Variables[e.Variable] = e.Value
+		})
+	case *events.Hangup:
+		change, ok = t.remove(e, e.Uniqueid)
+	}
+
+	if ok {
+		t.notify(change)
+	}
+}
+
+func (t *Tracker) update(e events.Event, snapshot events.ChannelSnapshot, fn func(*Channel)) (Change, bool) {
+	if snapshot.Uniqueid == "" {
+		return Change{}, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.set(e, snapshot, fn), true
+}
+
+// set stores the snapshot, must be called under mu.
+func (t *Tracker) set(e events.Event, snapshot events.ChannelSnapshot, fn func(*Channel)) Change {
+	now := time.Now()
+
+	change := Change{Type: ChannelUpdated, Event: e}
+
+	ch, ok := t.channels[snapshot.Uniqueid]
+	if !ok {
+		ch = &Channel{Created: now}
+		t.channels[snapshot.Uniqueid] = ch
+		change.Type = ChannelAdded
+	}
+
+	t.link(ch.Linkedid, snapshot.Linkedid, snapshot.Uniqueid)
+	t.seen(snapshot.Uniqueid)
+
+	ch.ChannelSnapshot = snapshot
+	ch.Updated = now
+
+	if fn != nil {
+		fn(ch)
+	}
+
+	change.Channel = ch.clone()
+
+	return change
+}
+
+func (t *Tracker) remove(e events.Event, uniqueid string) (Change, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.seen(uniqueid)
+
+	ch, ok := t.channels[uniqueid]
+	if !ok {
+		return Change{}, false
+	}
+
+	if h, isHangup := e.(*events.Hangup); isHangup {
+		ch.ChannelSnapshot = h.ChannelSnapshot
+	}
+
+	delete(t.channels, uniqueid)
+	t.link(ch.Linkedid, "", uniqueid)
+
+	ch.Updated = time.Now()
+
+	return Change{Type: ChannelRemoved, Channel: *ch, Event: e}, true
+}
+
+// seen keeps the channel out of the stale ones of the running bootstrap, must be called under mu.
+func (t *Tracker) seen(uniqueid string) {
+	if t.merge != nil {
+		t.merge.Seen(uniqueid)
+	}
+}
+
+// link moves the channel from the old Linkedid group to the new one, must be called under mu.
+func (t *Tracker) link(old, linkedid, uniqueid string) {
+	if old == linkedid {
+		return
+	}
+
+	if group, ok := t.linked[old]; ok {
+		delete(group, uniqueid)
+
+		if len(group) == 0 {
+			delete(t.linked, old)
+		}
+	}
+
+	if linkedid == "" {
+		return
+	}
+
+	group, ok := t.linked[linkedid]
+	if !ok {
+		group = make(map[string]struct{})
+		t.linked[linkedid] = group
+	}
+
+	group[uniqueid] = struct{}{}
+}
+
+func (t *Tracker) notify(change Change) {
+	t.listenersMu.Lock()
+
+	listeners := make([]func(Change), 0, len(t.listeners))
+	for _, fn := range t.listeners {
+		listeners = append(listeners, fn)
+	}
+
+	t.listenersMu.Unlock()
+
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+// bootstrap loads the current channels. The list is delivered with the events and applied in
+// the order Asterisk sent them, see handleList.
+func (t *Tracker) bootstrap(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	defer cancel()
+
+	id := t.client.NewActionID()
+	merge := amiclient.NewListMerge(id)
+
+	action := amiclient.NewAction("CoreShowChannels")
+	action.Add("ActionID", id)
+
+	t.mu.Lock()
+	t.merge = merge
+	t.mu.Unlock()
+
+	err := t.sub.DoList(ctx, action)
+	if err != nil {
+		logger.L().Warn("channel tracker bootstrap failed", zap.Error(err))
+
+		t.mu.Lock()
+		if t.merge == merge {
+			t.merge = nil
+		}
+		t.mu.Unlock()
+	}
+}
+
+// handleList applies the messages of the bootstrap list, it returns false for the events.
+// Channels missing in the list are removed unless an event read after the list response changed them.
+func (t *Tracker) handleList(msg amiclient.Message) bool {
+	var changes []Change
+
+	t.mu.Lock()
+
+	if t.merge == nil {
+		t.mu.Unlock()
+
+		return false
+	}
+
+	switch t.merge.Read(msg) {
+	case amiclient.ListEvent:
+		t.mu.Unlock()
+
+		return false
+	case amiclient.ListItem:
+		var e events.CoreShowChannel
+
+		_ = amiclient.Unmarshal(msg, &e)
+		if e.Uniqueid != "" {
+			changes = append(changes, t.set(&e, e.ChannelSnapshot, func(ch *Channel) {
+				ch.Application, ch.AppData, ch.BridgeID = e.Application, e.ApplicationData, e.BridgeID
+			}))
+		}
+	case amiclient.ListDone:
+		if t.merge.Complete() {
+			changes = t.removeStale()
+			t.once.Do(func() { close(t.bootstrapped) })
+		}
+
+		t.merge = nil
+	case amiclient.ListResponse, amiclient.ListComplete:
+	}
+
+	t.mu.Unlock()
+
+	for _, change := range changes {
+		t.notify(change)
+	}
+
+	return true
+}
+
+// removeStale removes the channels the bootstrap missed, must be called under mu.
+func (t *Tracker) removeStale() []Change {
+	var changes []Change
+
+	for id, ch := range t.channels {
+		if !t.merge.Stale(id) {
+			continue
+		}
+
+		delete(t.channels, id)
+		t.link(ch.Linkedid, "", id)
+
+		changes = append(changes, Change{Type: ChannelRemoved, Channel: *ch})
+	}
+
+	return changes
+}
@@ -0,0 +1,178 @@
+package amiclient
+
+import (
+	"strings"
+)
+
+const defaultSubscriptionBufferSize = 100
+
+// Filter selects messages for a subscription, all set conditions must match.
+// Empty filter matches every message of the event stream.
+type Filter struct {
+	// Events are event names matched case-insensitively.
+	Events []string
+	// Headers must have exactly these values.
+	Headers map[string]string
+	// Match is called last for the messages passed the other conditions.
+	Match func(Message) bool
+}
+
+func (f Filter) matches(msg Message) bool {
+	if len(f.Events) != 0 {
+		event := msg.Get("Event")
+		found := false
+
+		for _, name := range f.Events {
+			if strings.EqualFold(name, event) {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for key, value := range f.Headers {
+		if v, ok := msg.Lookup(key); !ok || v != value {
+			return false
+		}
+	}
+
+	return f.Match == nil || f.Match(msg)
+}
+
+// Subscription receives the messages of the event stream matching its filter.
+type Subscription struct {
+	c      *Client
+	id     uint64
+	filter Filter
+	sink   *sink
+}
+
+// Subscribe registers a buffered subscription, the channel returned by C is closed on Unsubscribe.
+func (c *Client) Subscribe(filter Filter) (*Subscription, error) {
+	return c.subscribe(filter)
+}
+
+// SubscribeFunc calls handler for every matching message from a dedicated goroutine,
+// so a slow handler does not delay other subscribers until its buffer is full.
+func (c *Client) SubscribeFunc(filter Filter, handler func(Message)) (*Subscription, error) {
+	s, err := c.subscribe(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for msg := range s.sink.ch {
+			handler(msg)
+		}
+	}()
+
+	return s, nil
+}
+
+func (c *Client) subscribe(filter Filter) (*Subscription, error) {
+	size := c.settings.SubscriptionBufferSize
+	if size <= 0 {
+		size = defaultSubscriptionBufferSize
+	}
+
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+
+	c.subSeq++
+
+	s := &Subscription{
+		c:      c,
+		id:     c.subSeq,
+		filter: filter,
+		sink:   c.newSink(make(chan Message, size)),
+	}
+
+	subs := make([]*Subscription, 0, len(c.subscriptions())+1)
+	subs = append(subs, c.subscriptions()...)
+	c.subs.Store(append(subs, s))
+
+	return s, nil
+}
+
+func (s *Subscription) C() <-chan Message {
+	return s.sink.ch
+}
+
+// Unsubscribe stops the delivery and closes the channel, it is safe to call it more than once.
+func (s *Subscription) Unsubscribe() {
+	s.c.removeSubscription(s.id)
+	s.sink.close()
+}
+
+// deliver reports whether the message matched, the subscription holds it then.
+func (s *Subscription) deliver(msg Message) bool {
+	if !s.filter.matches(msg) {
+		return false
+	}
+
+	s.sink.deliver(msg)
+
+	return true
+}
+
+func (c *Client) newSink(ch chan Message) *sink {
+	return newSink(ch, c.settings.Backpressure, c.settings.SpillBufferSize, c.metrics)
+}
+
+func (c *Client) subscriptions() []*Subscription {
+	subs, _ := c.subs.Load().([]*Subscription)
+
+	return subs
+}
+
+func (c *Client) removeSubscription(id uint64) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+
+	current := c.subscriptions()
+	subs := make([]*Subscription, 0, len(current))
+
+	for _, s := range current {
+		if s.id != id {
+			subs = append(subs, s)
+		}
+	}
+
+	c.subs.Store(subs)
+}
+
+func (c *Client) closeSubscriptions() {
+	c.subsMu.Lock()
+	c.closed = true
+	subs := c.subscriptions()
+	c.subsMu.Unlock()
+
+	for _, s := range subs {
+		s.Unsubscribe()
+	}
+}
+
+// dispatch fans the message out to MsgChan and the subscriptions, it reports whether any of them got it.
+func (c *Client) dispatch(msg Message) bool {
+	held := !c.settings.DisableMsgChan
+	if held {
+		c.msgSink.deliver(msg)
+	}
+
+	for _, s := range c.subscriptions() {
+		if s.deliver(msg) {
+			held = true
+		}
+	}
+
+	return held
+}
@@ -0,0 +1,170 @@
+package amiclient
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/Arten331/observability/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultReconnectMinDelay = 500 * time.Millisecond
+	defaultReconnectMaxDelay = 30 * time.Second
+)
+
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "Disconnected"
+	case StateConnecting:
+		return "Connecting"
+	case StateConnected:
+		return "Connected"
+	default:
+		return "Unknown"
+	}
+}
+
+func (c *Client) State() ConnectionState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.state
+}
+
+// OnStateChange registers a listener called on every connection state change,
+// the returned function removes it. Listeners are called synchronously and must not block.
+func (c *Client) OnStateChange(fn func(ConnectionState)) func() {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.listenerSeq++
+	id := c.listenerSeq
+	c.stateListeners[id] = fn
+
+	return func() {
+		c.stateMu.Lock()
+		defer c.stateMu.Unlock()
+
+		delete(c.stateListeners, id)
+	}
+}
+
+func (c *Client) setState(state ConnectionState) {
+	c.stateMu.Lock()
+
+	if c.state == state {
+		c.stateMu.Unlock()
+
+		return
+	}
+
+	c.state = state
+
+	listeners := make([]func(ConnectionState), 0, len(c.stateListeners))
+	for _, fn := range c.stateListeners {
+		listeners = append(listeners, fn)
+	}
+
+	c.stateMu.Unlock()
+
+	for _, fn := range listeners {
+		fn(state)
+	}
+}
+
+// supervise runs the reader and restores the connection after failures.
+func (c *Client) supervise(ctx context.Context) {
+	for {
+		err := c.readLoop(ctx)
+		if err == nil || errors.Is(err, errConnectionReplaced) || c.stopped(ctx) {
+			return
+		}
+
+		logger.L().Warn("AMI connection lost", zap.Error(err))
+		c.setState(StateDisconnected)
+		c.sendErr(err)
+
+		if !c.reconnect(ctx) {
+			return
+		}
+	}
+}
+
+func (c *Client) reconnect(ctx context.Context) bool {
+	minDelay, maxDelay := c.settings.ReconnectMinDelay, c.settings.ReconnectMaxDelay
+	if minDelay <= 0 {
+		minDelay = defaultReconnectMinDelay
+	}
+
+	if maxDelay <= 0 {
+		maxDelay = defaultReconnectMaxDelay
+	}
+
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+
+	delay := minDelay
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-c.stopReader:
+			return false
+		case <-time.After(withJitter(delay)):
+		}
+
+		err := c.connect(ctx)
+		if err == nil && c.stopped(ctx) {
+			_ = c.connection().Close()
+
+			return false
+		}
+
+		if err == nil {
+			logger.L().Info("AMI connection restored", zap.Int("attempt", attempt))
+
+			return true
+		}
+
+		logger.L().Warn("AMI reconnect failed", zap.Int("attempt", attempt), zap.Error(err))
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+func (c *Client) stopped(ctx context.Context) bool {
+	return ctx.Err() != nil || c.stoppedReader()
+}
+
+func (c *Client) stoppedReader() bool {
+	select {
+	case <-c.stopReader:
+		return true
+	default:
+		return false
+	}
+}
+
+// withJitter spreads the delay over [delay/2, delay] so clients do not reconnect in lockstep.
+func withJitter(delay time.Duration) time.Duration {
+	half := delay / 2
+
+	return half + time.Duration(rand.Int63n(int64(half)+1)) //nolint:gosec // jitter only
+}
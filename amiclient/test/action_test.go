@@ -1,9 +1,15 @@
 package test_test
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"io"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Arten331/telephony/amiclient"
 )
@@ -11,31 +17,31 @@ import (
 type ParseActionTC struct {
 	name           string
 	input          []byte
-	expectedResult amiclient.Message
+	expectedResult map[string]string
 }
 
 type SerialiseActionTC struct {
 	name           string
-	input          amiclient.Action
-	expectedResult amiclient.Action
+	input          map[string]string
+	expectedResult map[string]string
 }
 
 func TestAction_Serialize(t *testing.T) {
 	testCases := []SerialiseActionTC{
 		{
 			name: "login response success",
-			input: amiclient.Action{
+			input: map[string]string{
 				"Response": "Success",
 				"Message":  "Authentication accepted",
 			},
-			expectedResult: amiclient.Action{
+			expectedResult: map[string]string{
 				"Response": "Success",
 				"Message":  "Authentication accepted",
 			},
 		},
 		{
 			name: "hangup normal clearing",
-			input: amiclient.Action{
+			input: map[string]string{
 				"Message":           "Hangup",
 				"Privilege":         "call,all",
 				"Channel":           "Local/979144181775@phonenumber-checker-00000147;2",
@@ -55,7 +61,7 @@ func TestAction_Serialize(t *testing.T) {
 				"cause":             "16",
 				"cause-txt":         "Normal Clearing",
 			},
-			expectedResult: amiclient.Action{
+			expectedResult: map[string]string{
 				"Message":           "Hangup",
 				"Privilege":         "call,all",
 				"Channel":           "Local/979144181775@phonenumber-checker-00000147;2",
@@ -82,13 +88,13 @@ func TestAction_Serialize(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			var buf bytes.Buffer
 
-			resBytes := tc.input.Serialize()
+			resBytes := amiclient.ActionFromMap(tc.input).Serialize()
 
 			buf.Write(resBytes)
 
 			res := amiclient.ParseAction(buf)
 
-			if !reflect.DeepEqual(res, tc.expectedResult) {
+			if !reflect.DeepEqual(res, amiclient.ActionFromMap(tc.expectedResult)) {
 				t.Error("Wrong expected result", "result:", res, "expected", tc.expectedResult)
 			}
 		})
@@ -100,7 +106,7 @@ func TestParseEvent(t *testing.T) {
 		{
 			name:  "login response success",
 			input: []byte("Response: Success\nMessage: Authentication accepted"),
-			expectedResult: amiclient.Message{
+			expectedResult: map[string]string{
 				"Response": "Success",
 				"Message":  "Authentication accepted",
 			},
@@ -108,7 +114,7 @@ func TestParseEvent(t *testing.T) {
 		{
 			name:  "hangup normal clearing",
 			input: []byte("Message: Hangup\nPrivilege: call,all\nChannel: Local/979144181775@phonenumber-checker-00000147;2\nChannelState: 4\nChannelStateDesc: Ring\nCallerIDNum: <unknown>\nCallerIDName: <unknown>\nConnectedLineNum: <unknown>\nConnectedLineName: <unknown>\nLanguage: en\nAccountCode: phonenumber-checker\nContext: phonenumber-checker\nExten: h\nPriority: 1\nUniqueid: 1651218111.2444\nLinkedid: 1651218111.2443\ncause: 16\ncause-txt: Normal Clearing"),
-			expectedResult: amiclient.Message{
+			expectedResult: map[string]string{
 				"Message":           "Hangup",
 				"Privilege":         "call,all",
 				"Channel":           "Local/979144181775@phonenumber-checker-00000147;2",
@@ -129,6 +135,25 @@ func TestParseEvent(t *testing.T) {
 				"cause-txt":         "Normal Clearing",
 			},
 		},
+		{
+			name:  "value with delimiter",
+			input: []byte("Response: Error\nMessage: Error: channel not found\nOutput:   indented"),
+			expectedResult: map[string]string{
+				"Response": "Error",
+				"Message":  "Error: channel not found",
+				"Output":   "  indented",
+			},
+		},
+		{
+			name:  "missing space and colon",
+			input: []byte("Event:Hangup\nCause :16\nMalformed line\nCause-txt:"),
+			expectedResult: map[string]string{
+				"Event":          "Hangup",
+				"Cause":          "16",
+				"Malformed line": "",
+				"Cause-txt":      "",
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -139,9 +164,146 @@ func TestParseEvent(t *testing.T) {
 
 			res := amiclient.ParseMessage(buf)
 
-			if !reflect.DeepEqual(res, tc.expectedResult) {
+			if !reflect.DeepEqual(res.Map(), tc.expectedResult) {
 				t.Error("Wrong expected result", "result:", res, "expected", tc.expectedResult)
 			}
 		})
 	}
 }
+
+func TestHeaders(t *testing.T) {
+	var buf bytes.Buffer
+
+	originate := amiclient.NewAction("Originate")
+	originate.Add("Channel", "Local/979144181775@phonenumber-checker")
+	originate.Add("Variable", "a=1")
+	originate.Add("Variable", "b=2")
+	originate.Set("Async", "false")
+	originate.Set("async", "true")
+
+	expected := "Action: Originate\nChannel: Local/979144181775@phonenumber-checker\n" +
+		"Variable: a=1\nVariable: b=2\nAsync: true\n\n"
+
+	if string(originate.Serialize()) != expected {
+		t.Fatalf("Wrong serialized action:\n%s", originate.Serialize())
+	}
+
+	buf.Write(originate.Serialize())
+
+	res := amiclient.ParseMessage(buf)
+
+	if !reflect.DeepEqual(res, originate) {
+		t.Errorf("Order or repeated headers lost, result: %v, expected: %v", res, originate)
+	}
+
+	if v := res.GetAll("variable"); !reflect.DeepEqual(v, []string{"a=1", "b=2"}) {
+		t.Errorf("Wrong GetAll result %v", v)
+	}
+
+	if res.Get("ASYNC") != "true" || res.Map()["Variable"] != "a=1" {
+		t.Errorf("Wrong Get result %v", res)
+	}
+
+	res.Del("Variable")
+
+	if res.Has("Variable") || len(res) != 3 {
+		t.Errorf("Variable not deleted %v", res)
+	}
+}
+
+func TestParser(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	input := "Response: Error\r\nMessage: Error: channel not found\r\nAlone\r\n\r\n" +
+		"\n\nEvent: VarSet\nValue: " + long + "\n\n" +
+		"Response: Follows\nPrivilege: Command\nActionID: 1\nline: one\n\nline two\n--END COMMAND--\n\n" +
+		"Event: FullyBooted"
+
+	parser := amiclient.NewParser(bufio.NewReaderSize(strings.NewReader(input), 16))
+
+	expected := []amiclient.Message{
+		{{Key: "Response", Value: "Error"}, {Key: "Message", Value: "Error: channel not found"}, {Key: "Alone"}},
+		{{Key: "Event", Value: "VarSet"}, {Key: "Value", Value: long}},
+		{
+			{Key: "Response", Value: "Follows"}, {Key: "Privilege", Value: "Command"}, {Key: "ActionID", Value: "1"},
+			{Key: "Output", Value: "line: one"}, {Key: "Output"}, {Key: "Output", Value: "line two"},
+		},
+		{{Key: "Event", Value: "FullyBooted"}},
+	}
+
+	for _, e := range expected {
+		msg, err := parser.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(msg, e) {
+			t.Errorf("Wrong message %v, expected %v", msg, e)
+		}
+	}
+
+	if _, err := parser.Next(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+func TestParser_Timeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+
+	parser := amiclient.NewParser(bufio.NewReader(client))
+
+	go func() {
+		_, _ = server.Write([]byte("Event: Ping\nTimes"))
+	}()
+
+	_ = client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+	msg, err := parser.Next()
+	if msg != nil || err != nil {
+		t.Fatalf("Expected empty result on timeout, got %v %v", msg, err)
+	}
+
+	go func() {
+		_, _ = server.Write([]byte("tamp: 1\n\n"))
+	}()
+
+	_ = client.SetReadDeadline(time.Time{})
+
+	msg, err = parser.Next()
+	if err != nil || msg.Get("Event") != "Ping" || msg.Get("Timestamp") != "1" {
+		t.Errorf("Interrupted message not completed, got %v %v", msg, err)
+	}
+}
+
+func TestParseMessageStrict(t *testing.T) {
+	var buf bytes.Buffer
+
+	buf.WriteString("Action: Ping\nActionID 1\n")
+
+	res := amiclient.ParseAction(buf)
+	if res.Get("Action") != "Ping" || !res.Has("ActionID 1") {
+		t.Errorf("Wrong tolerant result %v", res)
+	}
+
+	buf.Reset()
+	buf.WriteString("Action: Ping\nActionID 1\n")
+
+	_, err := amiclient.ParseMessageStrict(buf)
+	if !errors.Is(err, amiclient.ErrMalformedHeader) {
+		t.Errorf("Expected ErrMalformedHeader, got %v", err)
+	}
+
+	parser := amiclient.NewParser(bufio.NewReader(strings.NewReader(
+		"Event: Bad\nno colon here\nUniqueid: 1\n\nEvent: Good\n\n")))
+	parser.Strict = true
+
+	if _, err = parser.Next(); !errors.Is(err, amiclient.ErrMalformedHeader) {
+		t.Errorf("Expected ErrMalformedHeader, got %v", err)
+	}
+
+	msg, err := parser.Next()
+	if err != nil || msg.Get("Event") != "Good" || len(msg) != 1 {
+		t.Errorf("Stream not in sync after malformed message, got %v %v", msg, err)
+	}
+}
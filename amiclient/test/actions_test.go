@@ -0,0 +1,107 @@
+package test_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/actions"
+)
+
+type BuildActionTC struct {
+	name           string
+	input          actions.Action
+	expectedResult string
+	expectedErr    error
+}
+
+func TestBuild(t *testing.T) {
+	testCases := []BuildActionTC{
+		{
+			name: "originate to dialplan",
+			input: actions.Originate{
+				Channel:  "Local/979144181775@phonenumber-checker",
+				Exten:    "979144181775",
+				Context:  "phonenumber-checker",
+				Priority: 1,
+				Timeout:  30000,
+				Variable: map[string]string{"b": "2", "a": "1"},
+			},
+			expectedResult: "Action: Originate\nChannel: Local/979144181775@phonenumber-checker\n" +
+				"Exten: 979144181775\nContext: phonenumber-checker\nPriority: 1\nTimeout: 30000\n" +
+				"Variable: a=1\nVariable: b=2\nAsync: true\n\n",
+		},
+		{
+			name:           "sync originate",
+			input:          actions.Originate{Channel: "SIP/1001", Application: "Playback", Data: "hello", Sync: true},
+			expectedResult: "Action: Originate\nChannel: SIP/1001\nApplication: Playback\nData: hello\nAsync: false\n\n",
+		},
+		{
+			name:           "queue pause keeps false",
+			input:          actions.QueuePause{Interface: "SIP/1001", Paused: false},
+			expectedResult: "Action: QueuePause\nInterface: SIP/1001\nPaused: false\n\n",
+		},
+		{
+			name:        "originate without target",
+			input:       actions.Originate{Channel: "SIP/1001"},
+			expectedErr: actions.ErrInvalidAction,
+		},
+		{
+			name: "originate with both targets",
+			input: actions.Originate{
+				Channel: "SIP/1001", Exten: "100", Context: "default", Priority: 1, Application: "Playback",
+			},
+			expectedErr: actions.ErrInvalidAction,
+		},
+		{
+			name:        "hangup without channel",
+			input:       actions.Hangup{Cause: 16},
+			expectedErr: amiclient.ErrRequiredHeader,
+		},
+		{
+			name:        "module load without module",
+			input:       actions.ModuleLoad{LoadType: actions.LoadTypeLoad},
+			expectedErr: actions.ErrInvalidAction,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := actions.Build(tc.input)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if err == nil && string(res.Serialize()) != tc.expectedResult {
+				t.Errorf("Wrong expected result\nresult:\n%s\nexpected:\n%s", res.Serialize(), tc.expectedResult)
+			}
+		})
+	}
+}
+
+func TestDo_Getvar(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	client := startTestClient(ctx, t)
+
+	var resp actions.GetvarResponse
+
+	err := actions.Do(ctx, client, actions.Getvar{Channel: "SIP/1001-00000001", Variable: "DIALEDNUMBER"}, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Response.Response != "Success" || resp.Variable != "DIALEDNUMBER" || resp.Value != "979144181775" {
+		t.Errorf("Wrong Getvar response %+v", resp)
+	}
+
+	var hangup actions.HangupResponse
+
+	err = actions.Do(ctx, client, actions.Hangup{Channel: "SIP/1001-00000001"}, &hangup)
+	if !errors.Is(err, amiclient.ErrActionFailed) || hangup.Message != "Invalid/unknown command" {
+		t.Errorf("Expected failed Hangup, got %+v %v", hangup, err)
+	}
+}
@@ -2,18 +2,24 @@ package test_test
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/amitest"
 
 	"github.com/Arten331/observability/logger"
 )
 
-const testTCPPort = 40000
-
 func init() {
 	logger.MustSetupGlobal(
 		logger.WithConfiguration(logger.CoreOptions{
@@ -30,47 +36,41 @@ func TestClient(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	s := StartTestTCPServer(ctx, testTCPPort, true)
-	defer func() { _ = s.Close() }()
+	s := startTestServer(t, nil)
 
-	<-time.After(time.Second * 2)
-
-	client := amiclient.New(&amiclient.Settings{
-		Host:              "",
-		Port:              testTCPPort,
-		Username:          "test",
-		Password:          "test",
-		ConnectionTimeout: 30 * time.Second,
-	})
+	client := amiclient.New(s.ClientSettings())
 
 	err = client.Connect(ctx, true)
 	if err != nil {
 		t.Fatalf("Unable connect to test tcp server, %s", err.Error())
 	}
+	defer client.Disconnect()
 
 	t.Log("Connection successful")
 
-	commandTestData := make(amiclient.Action)
-	commandTestData["Action"] = "GiveMeTest"
+	commandTestData := amiclient.NewAction("GiveMeTest")
 
 	err = client.SendCommand(commandTestData)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	wg := sync.WaitGroup{}
 	waitCh := make(chan struct{})
 
-	wg.Add(4)
+	// FullyBooted sent after the login and the 4 test messages
+	wg.Add(5)
 
 	ctx, cancel = context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	msgs := make([]amiclient.Message, 0, 4)
+	msgs := make([]amiclient.Message, 0, 5)
 
 	go func(t *testing.T) {
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case err := <-client.ErrChan():
 				t.Errorf("Error while read test messages, %s", err.Error())
 			case msg := <-client.MsgChan():
@@ -86,14 +86,592 @@ func TestClient(t *testing.T) {
 		close(waitCh)
 	}()
 
-	fmt.Println(msgs)
-
 	select {
 	case <-ctx.Done():
 		if ctx.Err() == context.DeadlineExceeded {
-			t.Errorf("4 test messages not come back, %s", ctx.Err())
-			wg.Add(4)
+			t.Errorf("5 test messages not come back, %s", ctx.Err())
 		}
 	case <-waitCh:
+		for _, msg := range msgs {
+			if !checkMessage(msg) {
+				t.Errorf("Wrong received message, %v", msg)
+			}
+		}
+	}
+}
+
+func startTestClient(ctx context.Context, t *testing.T) *amiclient.Client {
+	t.Helper()
+
+	s := startTestServer(t, nil)
+	client := amiclient.New(s.ClientSettings())
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatalf("Unable connect to test tcp server, %s", err.Error())
+	}
+
+	return client
+}
+
+func TestClient_Do(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	client := startTestClient(ctx, t)
+
+	err := client.SendCommand(amiclient.NewAction("GiveMeTest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			id := "test-" + strconv.Itoa(i)
+
+			msg, err := client.Do(ctx, amiclient.ActionFromMap(map[string]string{"Action": "Ping", "ActionID": id}))
+			if err != nil {
+				t.Errorf("Do failed, %s", err)
+
+				return
+			}
+
+			if msg.Get("ActionID") != id || msg.Get("Ping") != "Pong" {
+				t.Errorf("Wrong response for %s: %v", id, msg)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	msg, err := client.Do(ctx, amiclient.NewAction("Ping"))
+	if err != nil || msg.Get("ActionID") == "" {
+		t.Errorf("Do without ActionID failed, %v %v", msg, err)
+	}
+
+	_, err = client.Do(ctx, amiclient.NewAction("Unknown"))
+	if !errors.Is(err, amiclient.ErrActionFailed) {
+		t.Errorf("Expected ErrActionFailed, got %v", err)
+	}
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer timeoutCancel()
+
+	_, err = client.Do(timeoutCtx, amiclient.NewAction("NoAnswer"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		select {
+		case msg := <-client.MsgChan():
+			if msg.Has("Ping") {
+				t.Errorf("Response leaked to event stream, %v", msg)
+			}
+		case <-ctx.Done():
+			t.Fatal("GiveMeTest messages not come back")
+		}
+	}
+}
+
+func TestClient_SendCommandConcurrent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	client := startTestClient(ctx, t)
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			// large actions with per call deadlines must come back intact
+			variable := strings.Repeat(strconv.Itoa(i), 64*1024)
+
+			action := amiclient.NewAction("Getvar")
+			action.Add("Variable", variable)
+
+			msg, err := client.Do(ctx, action)
+			if err != nil {
+				t.Errorf("Do failed, %s", err)
+
+				return
+			}
+
+			if msg.Get("Variable") != variable {
+				t.Errorf("Corrupted response for %d", i)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	canceled, cancelSend := context.WithCancel(ctx)
+	cancelSend()
+
+	err := client.SendCommandContext(canceled, amiclient.NewAction("Ping"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	_, err = client.Do(ctx, amiclient.NewAction("Ping"))
+	if err != nil {
+		t.Errorf("Connection broken after canceled send, %v", err)
+	}
+}
+
+func TestClient_DoList(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	client := startTestClient(ctx, t)
+
+	msgs, err := client.DoList(ctx, amiclient.NewAction("CoreShowChannels"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs) != 2 {
+		t.Fatalf("Expected 2 list events, got %d: %v", len(msgs), msgs)
+	}
+
+	for _, msg := range msgs {
+		if msg.Get("Event") != "CoreShowChannel" || msg.Get("Linkedid") != "1651218111.2443" {
+			t.Errorf("Wrong list event, %v", msg)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-client.MsgChan():
+			if msg.Get("Event") != "FullyBooted" {
+				t.Errorf("Unexpected event in stream, %v", msg)
+			}
+		case <-ctx.Done():
+			t.Fatal("Unrelated events not come back")
+		}
+	}
+
+	s, err := client.DoListStream(ctx, amiclient.NewAction("CoreShowChannels"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cnt := 0
+	for s.Next() {
+		cnt++
+	}
+
+	if s.Err() != nil || cnt != 2 || s.Complete().Get("ListItems") != "2" {
+		t.Errorf("Wrong stream result, items %d, complete %v, err %v", cnt, s.Complete(), s.Err())
+	}
+
+	_, err = client.DoList(ctx, amiclient.NewAction("Unknown"))
+	if !errors.Is(err, amiclient.ErrActionFailed) {
+		t.Errorf("Expected ErrActionFailed, got %v", err)
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	settings := s.ClientSettings()
+	settings.Reconnect = true
+	settings.ReconnectMinDelay = 10 * time.Millisecond
+	settings.ReconnectMaxDelay = 100 * time.Millisecond
+
+	client := amiclient.New(settings)
+
+	states := make(chan amiclient.ConnectionState, 10)
+	client.OnStateChange(func(state amiclient.ConnectionState) {
+		states <- state
+	})
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []amiclient.ConnectionState{amiclient.StateConnecting, amiclient.StateConnected}
+	checkStates := func() {
+		for _, state := range expected {
+			select {
+			case got := <-states:
+				if got != state {
+					t.Fatalf("Expected state %s, got %s", state, got)
+				}
+			case <-ctx.Done():
+				t.Fatalf("State %s not reached", state)
+			}
+		}
+	}
+
+	checkStates()
+
+	err = client.SendCommand(amiclient.NewAction("Drop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []amiclient.ConnectionState{
+		amiclient.StateDisconnected, amiclient.StateConnecting, amiclient.StateConnected,
+	}
+	checkStates()
+
+	select {
+	case err = <-client.ErrChan():
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("Expected EOF, got %v", err)
+		}
+	default:
+		t.Error("Connection error not reported")
+	}
+
+	msg, err := client.Do(ctx, amiclient.NewAction("Ping"))
+	if err != nil || msg.Get("Ping") != "Pong" {
+		t.Errorf("Ping after reconnect failed, %v %v", msg, err)
+	}
+}
+
+func TestClient_AuthMD5(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	testCases := []struct {
+		name     string
+		password string
+		success  bool
+	}{
+		{name: "valid key", password: "test", success: true},
+		{name: "wrong password", password: "BadGuy", success: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings := s.ClientSettings()
+			settings.Password = tc.password
+			settings.AuthMethod = amiclient.AuthMD5
+
+			client := amiclient.New(settings)
+
+			err := client.Connect(ctx, false)
+			if tc.success && err != nil {
+				t.Errorf("MD5 auth failed, %s", err)
+			}
+
+			if !tc.success && !errors.Is(err, amiclient.ErrAuthFailed) {
+				t.Errorf("Expected ErrAuthFailed for the wrong password, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_Command(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	client := startTestClient(ctx, t)
+
+	testCases := []struct {
+		name     string
+		command  string
+		expected []string
+		err      error
+	}{
+		{
+			name:    "response follows",
+			command: "core show channels",
+			expected: []string{
+				"Channel              Location             State   Application(Data)",
+				"SIP/pbx_sbc2_test-00000001 979144181775@phonenumber-checker Up Dial(Local/979144181775)",
+				"",
+				"Uptime: 1 hour, 5 minutes",
+				"1 active channel",
+			},
+		},
+		{
+			name:     "output headers",
+			command:  "core show uptime",
+			expected: []string{"System uptime: 1 hour, 5 minutes", "Last reload: 1 hour, 5 minutes"},
+		},
+		{
+			name:     "unknown command",
+			command:  "core show nothing",
+			expected: []string{"No such command 'core show nothing' (type 'core show help core show nothing' for other possible commands)"},
+			err:      amiclient.ErrActionFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := client.Command(ctx, tc.command)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Expected error %v, got %v", tc.err, err)
+			}
+
+			if !reflect.DeepEqual(res.Output, tc.expected) {
+				t.Errorf("Wrong output %q, expected %q", res.Output, tc.expected)
+			}
+		})
+	}
+
+	msg, err := client.Do(ctx, amiclient.NewAction("Ping"))
+	if err != nil || msg.Get("Ping") != "Pong" {
+		t.Errorf("Stream broken after command output, %v %v", msg, err)
+	}
+}
+
+func TestClient_Banner(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	client := startTestClient(ctx, t)
+
+	if v := client.ProtocolVersion(); v != (amiclient.Version{Major: 2, Minor: 10, Patch: 5}) {
+		t.Errorf("Wrong protocol version %s", v)
+	}
+
+	if !client.ProtocolVersion().AtLeast(2, 10) || client.ProtocolVersion().AtLeast(5, 0) {
+		t.Error("Wrong AtLeast result")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_8.9\r\n"))
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	client = amiclient.New(&amiclient.Settings{
+		Port:              l.Addr().(*net.TCPAddr).Port,
+		ConnectionTimeout: time.Second,
+	})
+
+	err = client.Connect(ctx, false)
+	if !errors.Is(err, amiclient.ErrInvalidBanner) {
+		t.Errorf("Expected ErrInvalidBanner, got %v", err)
+	}
+}
+
+func TestClient_Keepalive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	settings := s.ClientSettings()
+	settings.Reconnect = true
+	settings.ReconnectMinDelay = 10 * time.Millisecond
+	settings.PingInterval = 50 * time.Millisecond
+	settings.PingTimeout = 50 * time.Millisecond
+
+	client := amiclient.New(settings)
+
+	states := make(chan amiclient.ConnectionState, 10)
+	client.OnStateChange(func(state amiclient.ConnectionState) {
+		states <- state
+	})
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a few successful pings keep the connection
+	<-time.After(200 * time.Millisecond)
+
+	select {
+	case err = <-client.ErrChan():
+		t.Fatalf("Unexpected connection error %v", err)
+	default:
+	}
+
+	_, err = client.Do(ctx, amiclient.NewAction("MutePing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err = <-client.ErrChan():
+		if !errors.Is(err, amiclient.ErrPingTimeout) {
+			t.Errorf("Expected ErrPingTimeout, got %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Dead connection not detected")
+	}
+
+	for {
+		select {
+		case state := <-states:
+			if state != amiclient.StateConnected || len(states) != 0 {
+				continue
+			}
+		case <-ctx.Done():
+			t.Fatal("Client not reconnected")
+		}
+
+		break
+	}
+
+	if latency := pingLatencyCount(t, client); latency == 0 {
+		t.Error("Ping latency not observed")
+	}
+}
+
+func pingLatencyCount(t *testing.T, client *amiclient.Client) uint64 {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(client.GetMetrics()...)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() == "ami_ping_latency_seconds" {
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+
+	return 0
+}
+
+func TestClient_Close(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	s.Handle("NoAnswer", amitest.NoResponse)
+
+	settings := s.ClientSettings()
+	settings.Reconnect = true
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := client.Subscribe(amiclient.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := make(chan error, 1)
+
+	go func() {
+		_, err := client.Do(ctx, amiclient.NewAction("NoAnswer"))
+		pending <- err
+	}()
+
+	<-time.After(50 * time.Millisecond)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := client.Close(ctx); err != nil {
+				t.Errorf("Close failed: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err = <-pending; !errors.Is(err, amiclient.ErrClientClosed) {
+		t.Errorf("Expected ErrClientClosed for in-flight action, got %v", err)
+	}
+
+	if len(s.ReceivedActions("Logoff")) != 1 {
+		t.Error("Logoff not sent")
+	}
+
+	if client.State() != amiclient.StateDisconnected {
+		t.Errorf("Expected Disconnected state, got %s", client.State())
+	}
+
+	if _, ok := <-sub.C(); ok {
+		t.Error("Subscription channel not closed")
+	}
+
+	// FullyBooted sent after login is still buffered
+	for range client.MsgChan() {
+	}
+
+	if _, ok := <-client.ErrChan(); ok {
+		t.Error("ErrChan not closed")
+	}
+
+	if _, err = client.Subscribe(amiclient.Filter{}); !errors.Is(err, amiclient.ErrClientClosed) {
+		t.Errorf("Expected ErrClientClosed on Subscribe, got %v", err)
+	}
+
+	if _, err = client.Do(ctx, amiclient.NewAction("Ping")); !errors.Is(err, amiclient.ErrClientClosed) {
+		t.Errorf("Expected ErrClientClosed on Do, got %v", err)
+	}
+
+	if err = client.Connect(ctx, true); !errors.Is(err, amiclient.ErrClientClosed) {
+		t.Errorf("Expected ErrClientClosed on Connect, got %v", err)
+	}
+
+	client.Disconnect()
+}
+
+func TestClient_CloseWithoutReader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	client := amiclient.New(s.ClientSettings())
+
+	err := client.Connect(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody reads the Logoff response, Close must not wait for it
+	start := time.Now()
+
+	if err = client.Close(ctx); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Close waited %s for the Logoff response", elapsed)
+	}
+
+	if _, err = s.WaitAction(ctx, "Logoff"); err != nil {
+		t.Error("Logoff not sent")
 	}
 }
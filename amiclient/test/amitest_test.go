@@ -0,0 +1,290 @@
+package test_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/amitest"
+)
+
+func TestFakeServer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	s.Handle("Getvar", func(c *amitest.Conn, action amiclient.Action) {
+		c.Respond(action, amiclient.Message{
+			{Key: "Response", Value: "Success"},
+			{Key: "Variable", Value: action.Get("Variable")},
+			{Key: "Value", Value: "42"},
+		})
+	})
+	s.Handle("CoreShowChannels", amitest.List("CoreShowChannel",
+		amiclient.Message{{Key: "Channel", Value: "SIP/a-00000001"}},
+		amiclient.Message{{Key: "Channel", Value: "SIP/b-00000002"}},
+	))
+
+	settings := s.ClientSettings()
+	settings.AuthMethod = amiclient.AuthMD5
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	if !client.ProtocolVersion().AtLeast(2, 10) {
+		t.Errorf("Wrong protocol version %s", client.ProtocolVersion())
+	}
+
+	sub, err := client.Subscribe(amiclient.Filter{Events: []string{"Hangup"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	action := amiclient.NewAction("Getvar")
+	action.Add("Variable", "CALLS")
+
+	msg, err := client.Do(ctx, action)
+	if err != nil || msg.Get("Value") != "42" {
+		t.Errorf("Wrong Getvar response %v %v", msg, err)
+	}
+
+	items, err := client.DoList(ctx, amiclient.NewAction("CoreShowChannels"))
+	if err != nil || len(items) != 2 || items[1].Get("Channel") != "SIP/b-00000002" {
+		t.Errorf("Wrong list %v %v", items, err)
+	}
+
+	_, err = client.Do(ctx, amiclient.NewAction("Unknown"))
+	if !errors.Is(err, amiclient.ErrActionFailed) {
+		t.Errorf("Expected ErrActionFailed, got %v", err)
+	}
+
+	s.Emit(amiclient.Message{{Key: "Event", Value: "Hangup"}, {Key: "Uniqueid", Value: "1"}})
+
+	select {
+	case msg = <-sub.C():
+		if msg.Get("Uniqueid") != "1" {
+			t.Errorf("Wrong event %v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("Event not received")
+	}
+
+	login := s.ReceivedActions("Login")
+	if len(login) != 1 || login[0].Get("AuthType") != "MD5" {
+		t.Errorf("Wrong recorded login %v", login)
+	}
+
+	if received := s.Received(); received[0].Get("Action") != "Challenge" {
+		t.Errorf("Wrong recorded actions %v", received)
+	}
+}
+
+func TestFakeServer_Drop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	s.Handle("Hangup", amitest.DropConnection)
+
+	settings := s.ClientSettings()
+	settings.Reconnect = true
+	settings.ReconnectMinDelay = 10 * time.Millisecond
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	_, err = client.Do(ctx, amiclient.NewAction("Hangup"))
+	if !errors.Is(err, amiclient.ErrConnectionLost) {
+		t.Errorf("Expected ErrConnectionLost, got %v", err)
+	}
+
+	waitLogins(ctx, t, s, client, 2)
+
+	s.Drop()
+
+	waitLogins(ctx, t, s, client, 3)
+}
+
+// waitLogins waits for n logins and checks the client works on the new connection.
+func waitLogins(ctx context.Context, t *testing.T, s *amitest.Server, client *amiclient.Client, n int) {
+	t.Helper()
+
+	for len(s.ReceivedActions("Login")) < n {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Client not reconnected, logins: %d", len(s.ReceivedActions("Login")))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	for client.State() != amiclient.StateConnected {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Connection not restored")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	_, err := client.Do(ctx, amiclient.NewAction("Ping"))
+	if err != nil {
+		t.Errorf("Ping after reconnect failed, %v", err)
+	}
+}
+
+func TestClient_ActionsDuringHandshake(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	// the handshake of the reconnect waits for release after a response of another action
+	release := make(chan struct{})
+
+	s.Handle("Challenge", func(c *amitest.Conn, action amiclient.Action) {
+		if len(s.ReceivedActions("Challenge")) > 1 {
+			_ = c.Send(append(amitest.Error("Permission denied"), amiclient.Header{Key: "ActionID", Value: "other"}))
+			<-release
+		}
+
+		c.Respond(action, amiclient.Message{
+			{Key: "Response", Value: "Success"},
+			{Key: "Challenge", Value: amitest.DefaultChallenge},
+		})
+	})
+
+	settings := s.ClientSettings()
+	settings.AuthMethod = amiclient.AuthMD5
+	settings.Reconnect = true
+	settings.ReconnectMinDelay = 10 * time.Millisecond
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	s.Drop()
+
+	for len(s.ReceivedActions("Challenge")) < 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Client not reconnected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	pingCtx, pingCancel := context.WithTimeout(ctx, time.Second)
+	defer pingCancel()
+
+	_, err = client.Do(pingCtx, amiclient.NewAction("Ping"))
+	if !errors.Is(err, amiclient.ErrConnectionLost) {
+		t.Errorf("Expected ErrConnectionLost during the handshake, got %v", err)
+	}
+
+	close(release)
+
+	waitLogins(ctx, t, s, client, 2)
+
+	if challenges := len(s.ReceivedActions("Challenge")); challenges != 2 {
+		t.Errorf("Expected the reconnect to log in at once, got %d challenges", challenges)
+	}
+}
+
+func TestClient_StaleReader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	client := amiclient.New(s.ClientSettings())
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	states := make(chan amiclient.ConnectionState, 10)
+	client.OnStateChange(func(state amiclient.ConnectionState) { states <- state })
+
+	// the new connection replaces the old one, its reader exits without touching the new state
+	err = client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.Do(ctx, amiclient.NewAction("Ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := client.State(); state != amiclient.StateConnected {
+		t.Errorf("Expected Connected, got %s", state)
+	}
+
+	for len(states) > 0 {
+		if state := <-states; state == amiclient.StateDisconnected {
+			t.Error("Stale reader disconnected the new connection")
+		}
+	}
+
+	select {
+	case err = <-client.ErrChan():
+		t.Errorf("Unexpected error of the stale reader %v", err)
+	default:
+	}
+}
+
+func TestClient_CloseWithPendingList(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	items := make([]amiclient.Message, 300)
+	for i := range items {
+		items[i] = amiclient.Message{{Key: "Uniqueid", Value: strconv.Itoa(i)}}
+	}
+
+	s := startTestServer(t, nil)
+	s.Handle("CoreShowChannels", amitest.List("CoreShowChannel", items...))
+
+	client := amiclient.New(s.ClientSettings())
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.DoListStream(ctx, amiclient.NewAction("CoreShowChannels"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reader is blocked on the full stream buffer, failing the stream must not panic it
+	time.Sleep(100 * time.Millisecond)
+
+	expired, expire := context.WithCancel(ctx)
+	expire()
+
+	_ = client.Close(expired)
+
+	for stream.Next() {
+	}
+
+	if err = stream.Err(); err == nil {
+		t.Error("Expected an error of the failed stream")
+	}
+}
@@ -0,0 +1,145 @@
+package test_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Arten331/telephony/amiclient"
+)
+
+type BackpressureTC struct {
+	name           string
+	policy         amiclient.BackpressurePolicy
+	spillSize      int
+	expectedResult []string
+	expectedDrops  float64
+}
+
+func TestClient_Backpressure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	testCases := []BackpressureTC{
+		{
+			name:           "drop newest",
+			policy:         amiclient.BackpressureDropNewest,
+			expectedResult: []string{"FullyBooted", "192.168.111.68:5060"},
+			expectedDrops:  2,
+		},
+		{
+			name:           "drop oldest",
+			policy:         amiclient.BackpressureDropOldest,
+			expectedResult: []string{"192.168.111.4:5060", "Success"},
+			expectedDrops:  2,
+		},
+		{
+			name:           "spill",
+			policy:         amiclient.BackpressureSpill,
+			spillSize:      2,
+			expectedResult: []string{"FullyBooted", "192.168.111.68:5060", "192.168.111.4:5060", "Success"},
+			expectedDrops:  0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings := s.ClientSettings()
+			settings.DisableMsgChan = true
+			settings.SubscriptionBufferSize = 2
+			settings.Backpressure = tc.policy
+			settings.SpillBufferSize = tc.spillSize
+
+			client := amiclient.New(settings)
+
+			err := client.Connect(ctx, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the FullyBooted sent after the login is dispatched before the pong
+			_, err = client.Do(ctx, amiclient.NewAction("Ping"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sub, err := client.Subscribe(amiclient.Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = client.SendCommand(amiclient.NewAction("GiveMeTest"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the reader handles messages in order, all test messages are dispatched before the pong
+			_, err = client.Do(ctx, amiclient.NewAction("Ping"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := make([]string, 0, len(tc.expectedResult))
+
+			for range tc.expectedResult {
+				select {
+				case msg := <-sub.C():
+					res = append(res, messageMark(msg))
+				case <-ctx.Done():
+					t.Fatalf("Messages not come back, received %v", res)
+				}
+			}
+
+			for i := range res {
+				if res[i] != tc.expectedResult[i] {
+					t.Fatalf("Wrong messages %v, expected %v", res, tc.expectedResult)
+				}
+			}
+
+			if drops := droppedMessages(t, client); drops != tc.expectedDrops {
+				t.Errorf("Expected %v dropped messages, got %v", tc.expectedDrops, drops)
+			}
+		})
+	}
+}
+
+func messageMark(msg amiclient.Message) string {
+	switch {
+	case msg.Has("Address"):
+		return msg.Get("Address")
+	case msg.Has("Event"):
+		return msg.Get("Event")
+	default:
+		return msg.Get("Response")
+	}
+}
+
+func droppedMessages(t *testing.T, client *amiclient.Client) float64 {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(client.GetMetrics()...)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res float64
+
+	for _, family := range families {
+		if family.GetName() != "ami_messages_dropped" {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			res += m.GetCounter().GetValue()
+		}
+	}
+
+	return res
+}
@@ -1,6 +1,8 @@
 package test_test
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"strconv"
 	"sync"
@@ -17,22 +19,15 @@ func BenchmarkMessages(b *testing.B) {
 
 	cnt := b.N
 
-	s := StartTestTCPServer(ctx, testTCPPort, false)
-	defer func() { _ = s.Close() }()
+	s := startTestServer(b, nil)
 
-	commandTestData := make(amiclient.Action)
-	commandTestData["Action"] = "GiveMeTest"
-	commandTestData["Count"] = strconv.Itoa(cnt)
+	commandTestData := amiclient.NewAction("GiveMeTest")
+	commandTestData.Add("Count", strconv.Itoa(cnt))
 
-	client := amiclient.New(&amiclient.Settings{
-		Host:              "",
-		Port:              testTCPPort,
-		Username:          "test",
-		Password:          "test",
-		ConnectionTimeout: 30 * time.Second,
-		Disabled:          false,
-		ReadTimeOut:       time.Second * 10,
-	})
+	settings := s.ClientSettings()
+	settings.ReadTimeOut = time.Second * 10
+
+	client := amiclient.New(settings)
 
 	err := client.Connect(ctx, true)
 	if err != nil {
@@ -79,33 +74,33 @@ func BenchmarkMessages(b *testing.B) {
 func checkMessage(msg amiclient.Message) bool {
 	isOK := true
 
-	_, isEvent := msg["Event"]
+	isEvent := msg.Has("Event")
 
 	if isEvent { //nolint:nestif
-		switch msg["Event"] {
+		switch msg.Get("Event") {
 		case "FullyBooted":
-			if msg["Privilege"] != "system,all" || msg["Status"] != "Fully Booted" {
+			if msg.Get("Privilege") != "system,all" || msg.Get("Status") != "Fully Booted" {
 				isOK = false
 
 				break
 			}
 		case "PeerStatus":
-			if msg["Address"] == "192.168.111.68:5060" {
-				if msg["Privilege"] != "system,all" ||
-					msg["ChannelType"] != "SIP" ||
-					msg["Peer"] != "SIP/pbx_sbc2_test" ||
-					msg["PeerStatus"] != "Registered" ||
-					msg["Address"] != "192.168.111.68:5060" {
+			if msg.Get("Address") == "192.168.111.68:5060" {
+				if msg.Get("Privilege") != "system,all" ||
+					msg.Get("ChannelType") != "SIP" ||
+					msg.Get("Peer") != "SIP/pbx_sbc2_test" ||
+					msg.Get("PeerStatus") != "Registered" ||
+					msg.Get("Address") != "192.168.111.68:5060" {
 					isOK = false
 
 					break
 				}
 			} else {
-				if msg["Privilege"] != "system,all" ||
-					msg["ChannelType"] != "SIP" ||
-					msg["Peer"] != "SIP/sbc_incoming_test" ||
-					msg["PeerStatus"] != "Registered" ||
-					msg["Address"] != "192.168.111.4:5060" {
+				if msg.Get("Privilege") != "system,all" ||
+					msg.Get("ChannelType") != "SIP" ||
+					msg.Get("Peer") != "SIP/sbc_incoming_test" ||
+					msg.Get("PeerStatus") != "Registered" ||
+					msg.Get("Address") != "192.168.111.4:5060" {
 					isOK = false
 
 					break
@@ -114,20 +109,20 @@ func checkMessage(msg amiclient.Message) bool {
 		}
 	}
 
-	_, isResponse := msg["Response"]
+	isResponse := msg.Has("Response")
 	if isResponse {
-		if msg["Response"] != "Success" ||
-			msg["AMIversion"] != "2.10.5" ||
-			msg["AsteriskVersion"] != "13.31.0" ||
-			msg["SystemName"] != "" ||
-			msg["CoreMaxCalls"] != "0" ||
-			msg["CoreMaxLoadAvg"] != "0.000000" ||
-			msg["CoreRunUser"] != "" ||
-			msg["CoreRunGroup"] != "" ||
-			msg["CoreMaxFilehandles"] != "0" ||
-			msg["CoreRealTimeEnabled"] != "Yes" ||
-			msg["CoreCDRenabled"] != "Yes" ||
-			msg["CoreHTTPenabled"] != "No" {
+		if msg.Get("Response") != "Success" ||
+			msg.Get("AMIversion") != "2.10.5" ||
+			msg.Get("AsteriskVersion") != "13.31.0" ||
+			msg.Get("SystemName") != "" ||
+			msg.Get("CoreMaxCalls") != "0" ||
+			msg.Get("CoreMaxLoadAvg") != "0.000000" ||
+			msg.Get("CoreRunUser") != "" ||
+			msg.Get("CoreRunGroup") != "" ||
+			msg.Get("CoreMaxFilehandles") != "0" ||
+			msg.Get("CoreRealTimeEnabled") != "Yes" ||
+			msg.Get("CoreCDRenabled") != "Yes" ||
+			msg.Get("CoreHTTPenabled") != "No" {
 			isOK = false
 		}
 	}
@@ -165,3 +160,94 @@ CoreMaxFilehandles: 0
 CoreRealTimeEnabled: Yes
 CoreCDRenabled: Yes
 CoreHTTPenabled: No*/
+
+func benchmarkStream(b *testing.B) []byte {
+	b.Helper()
+
+	data, err := fs.ReadFile("data/test_messages.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	data = append(bytes.TrimRight(data, "\n"), "\n\n"...)
+
+	return bytes.Repeat(data, 100)
+}
+
+// go test -test.bench=BenchmarkReadMessage -test.benchmem
+func BenchmarkReadMessage_Legacy(b *testing.B) {
+	data := benchmarkStream(b)
+	src := bytes.NewReader(data)
+	reader := bufio.NewReader(src)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		src.Reset(data)
+		reader.Reset(src)
+
+		for {
+			msg, err := legacyReadMessage(reader)
+			if err != nil {
+				break
+			}
+
+			if len(msg) != 0 && !checkMessage(msg) {
+				b.Fatalf("Wrong message %v", msg)
+			}
+		}
+	}
+}
+
+func BenchmarkReadMessage_Parser(b *testing.B) {
+	data := benchmarkStream(b)
+	src := bytes.NewReader(data)
+	reader := bufio.NewReader(src)
+	parser := amiclient.NewParser(reader)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		src.Reset(data)
+		reader.Reset(src)
+
+		for {
+			msg, err := parser.Next()
+			if err != nil {
+				break
+			}
+
+			if !checkMessage(msg) {
+				b.Fatalf("Wrong message %v", msg)
+			}
+		}
+	}
+}
+
+// legacyReadMessage is the line copying reader used before Parser, kept for comparison.
+func legacyReadMessage(r *bufio.Reader) (amiclient.Message, error) {
+	var buf bytes.Buffer
+
+	for {
+		line, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) <= 1 {
+			break
+		}
+
+		buf.Write(line)
+
+		if !isPrefix {
+			buf.Write([]byte{'\n'})
+		}
+	}
+
+	return amiclient.ParseMessage(buf), nil
+}
@@ -0,0 +1,312 @@
+package test_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/amitest"
+	"github.com/Arten331/telephony/amiclient/events"
+	"github.com/Arten331/telephony/amiclient/state"
+)
+
+func TestCallTracker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	client := amiclient.New(s.ClientSettings())
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	tracker := state.NewCallTracker(client)
+
+	completed := make(chan state.Call, 1)
+	tracker.OnComplete(func(c state.Call) { completed <- c })
+
+	err = tracker.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Stop()
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Newchannel", "Channel": "SIP/peer-1.1", "ChannelState": "4", "Uniqueid": "1.1", "Linkedid": "1.1",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "DialBegin", "Channel": "SIP/peer-1.1", "ChannelState": "4", "Uniqueid": "1.1", "Linkedid": "1.1",
+		"DestChannel": "SIP/peer-1.2", "DestChannelState": "0", "DestUniqueid": "1.2", "DestLinkedid": "1.1",
+		"DialString": "peer",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Newstate", "Channel": "SIP/peer-1.2", "ChannelState": "6", "ChannelStateDesc": "Up",
+		"Uniqueid": "1.2", "Linkedid": "1.1",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "DialEnd", "Channel": "SIP/peer-1.1", "ChannelState": "6", "Uniqueid": "1.1", "Linkedid": "1.1",
+		"DestChannel": "SIP/peer-1.2", "DestChannelState": "6", "DestUniqueid": "1.2", "DestLinkedid": "1.1",
+		"DialStatus": "ANSWER",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{"Event": "BridgeCreate", "BridgeUniqueid": "b1", "BridgeType": "basic"}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "BridgeEnter", "BridgeUniqueid": "b1", "BridgeType": "basic",
+		"Channel": "SIP/peer-1.1", "ChannelState": "6", "Uniqueid": "1.1", "Linkedid": "1.1",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "BridgeEnter", "BridgeUniqueid": "b1", "BridgeType": "basic",
+		"Channel": "SIP/peer-1.2", "ChannelState": "6", "Uniqueid": "1.2", "Linkedid": "1.1",
+	}))
+	s.Emit(amiclient.Message{
+		{Key: "Event", Value: "BlindTransfer"},
+		{Key: "Result", Value: "Success"},
+		{Key: "TransfererUniqueid", Value: "1.2"},
+		{Key: "TransfererBridgeUniqueid", Value: "b1"},
+		{Key: "TransfereeUniqueid", Value: "1.1"},
+		{Key: "Context", Value: "default"},
+		{Key: "Extension", Value: "100"},
+	})
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "BridgeLeave", "BridgeUniqueid": "b1", "BridgeType": "basic",
+		"Channel": "SIP/peer-1.2", "ChannelState": "6", "Uniqueid": "1.2", "Linkedid": "1.1",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Hangup", "Channel": "SIP/peer-1.2", "ChannelState": "6", "Uniqueid": "1.2", "Linkedid": "1.1",
+		"Cause": "16", "Cause-txt": "Normal Clearing",
+	}))
+
+	for {
+		c, ok := tracker.Call("1.1")
+		if ok && len(c.Legs) == 2 && !c.Legs[1].Ended.IsZero() {
+			if b, _ := tracker.Bridge("b1"); len(b.Channels) != 1 || b.Channels[0] != "1.1" {
+				t.Errorf("Wrong bridge %+v", b)
+			}
+
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Call not tracked, %+v", c)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "BridgeLeave", "BridgeUniqueid": "b1", "BridgeType": "basic",
+		"Channel": "SIP/peer-1.1", "ChannelState": "6", "Uniqueid": "1.1", "Linkedid": "1.1",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{"Event": "BridgeDestroy", "BridgeUniqueid": "b1", "BridgeType": "basic"}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Hangup", "Channel": "SIP/peer-1.1", "ChannelState": "6", "Uniqueid": "1.1", "Linkedid": "1.1", "Cause": "16",
+	}))
+
+	var c state.Call
+
+	select {
+	case c = <-completed:
+	case <-ctx.Done():
+		t.Fatal("Completed call not reported")
+	}
+
+	if c.Linkedid != "1.1" || len(c.Legs) != 2 || c.End.IsZero() || len(c.Bridges) != 1 {
+		t.Fatalf("Wrong call %+v", c)
+	}
+
+	callee2 := c.Legs[1]
+	if callee2.Caller != "1.1" || callee2.DialStatus != "ANSWER" || callee2.Answered.IsZero() || callee2.Cause != 16 {
+		t.Errorf("Wrong callee leg %+v", callee2)
+	}
+
+	var timeline []string
+	for _, entry := range c.Timeline {
+		timeline = append(timeline, entry.Event)
+	}
+
+	expected := []string{
+		"Newchannel", "DialBegin", "Newstate", "DialEnd", "BridgeEnter", "BridgeEnter",
+		"BlindTransfer", "BlindTransfer", "BridgeLeave", "Hangup", "BridgeLeave", "Hangup",
+	}
+
+	if len(timeline) != len(expected) {
+		t.Fatalf("Wrong timeline %v", timeline)
+	}
+
+	for i := range expected {
+		if timeline[i] != expected[i] {
+			t.Fatalf("Wrong timeline %v", timeline)
+		}
+	}
+
+	if _, ok := tracker.Call("1.1"); ok || len(tracker.Calls()) != 0 {
+		t.Error("Completed call left in progress")
+	}
+
+	if _, ok := tracker.Bridge("b1"); ok {
+		t.Error("Destroyed bridge left")
+	}
+}
+
+func TestCallTracker_Reconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	s.Handle("CoreShowChannels", amitest.List("CoreShowChannel", amiclient.MessageFromMap(map[string]string{
+		"Channel": "SIP/peer-4.2", "ChannelState": "6", "Uniqueid": "4.2", "Linkedid": "4.2",
+	})))
+
+	settings := s.ClientSettings()
+	settings.Reconnect = true
+	settings.ReconnectMinDelay = 10 * time.Millisecond
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	tracker := state.NewCallTracker(client)
+
+	completed := make(chan state.Call, 1)
+	tracker.OnComplete(func(c state.Call) { completed <- c })
+
+	err = tracker.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Stop()
+
+	// events without Uniqueid do not create legs
+	tracker.Apply(&events.Newstate{})
+	tracker.Apply(&events.DialBegin{})
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Newchannel", "Channel": "SIP/peer-4.1", "ChannelState": "4", "Uniqueid": "4.1", "Linkedid": "4.1",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Newchannel", "Channel": "SIP/peer-4.2", "ChannelState": "4", "Uniqueid": "4.2", "Linkedid": "4.2",
+	}))
+
+	for len(tracker.Calls()) != 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Expected 2 calls, got %+v", tracker.Calls())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// the Hangup of 4.1 is lost with the connection
+	s.Drop()
+
+	var c state.Call
+
+	select {
+	case c = <-completed:
+	case <-ctx.Done():
+		t.Fatal("Call missing after reconnect not completed")
+	}
+
+	if c.Linkedid != "4.1" || len(c.Legs) != 1 || c.Legs[0].Ended.IsZero() || c.End.IsZero() {
+		t.Errorf("Wrong expired call %+v", c)
+	}
+
+	if _, ok := tracker.Call("4.2"); !ok || len(tracker.Calls()) != 1 {
+		t.Errorf("Wrong calls after reconnect %+v", tracker.Calls())
+	}
+}
+
+func TestCallTracker_LinkedidChange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	client := amiclient.New(s.ClientSettings())
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	tracker := state.NewCallTracker(client)
+
+	completed := make(chan state.Call, 2)
+	tracker.OnComplete(func(c state.Call) { completed <- c })
+
+	err = tracker.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Stop()
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Newchannel", "Channel": "SIP/peer-5.1", "ChannelState": "6", "Uniqueid": "5.1", "Linkedid": "5.1",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Newchannel", "Channel": "SIP/peer-5.2", "ChannelState": "6", "Uniqueid": "5.2", "Linkedid": "5.2",
+	}))
+
+	// the transfer links 5.2 to the call of 5.1
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "BridgeEnter", "BridgeUniqueid": "b5", "BridgeType": "basic",
+		"Channel": "SIP/peer-5.1", "ChannelState": "6", "Uniqueid": "5.1", "Linkedid": "5.1",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "BridgeEnter", "BridgeUniqueid": "b5", "BridgeType": "basic",
+		"Channel": "SIP/peer-5.2", "ChannelState": "6", "Uniqueid": "5.2", "Linkedid": "5.1",
+	}))
+
+	for {
+		c, ok := tracker.Call("5.1")
+		if ok && len(c.Legs) == 2 {
+			if _, ok = tracker.Call("5.2"); ok || len(tracker.Calls()) != 1 {
+				t.Errorf("Leg not moved from its first call %+v", tracker.Calls())
+			}
+
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Leg not regrouped, %+v", tracker.Calls())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// the bridge is never destroyed
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Hangup", "Channel": "SIP/peer-5.2", "ChannelState": "6", "Uniqueid": "5.2", "Linkedid": "5.1", "Cause": "16",
+	}))
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Hangup", "Channel": "SIP/peer-5.1", "ChannelState": "6", "Uniqueid": "5.1", "Linkedid": "5.1", "Cause": "16",
+	}))
+
+	var c state.Call
+
+	select {
+	case c = <-completed:
+	case <-ctx.Done():
+		t.Fatal("Completed call not reported")
+	}
+
+	if c.Linkedid != "5.1" || len(c.Legs) != 2 || len(c.Bridges) != 1 || len(c.Timeline) != 6 {
+		t.Errorf("Wrong call %+v", c)
+	}
+
+	if b, ok := tracker.Bridge("b5"); ok {
+		t.Errorf("Bridge of the ended call left %+v", b)
+	}
+
+	select {
+	case c = <-completed:
+		t.Errorf("Unexpected completed call %+v", c)
+	default:
+	}
+}
@@ -0,0 +1,208 @@
+package test_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/amitest"
+	"github.com/Arten331/telephony/amiclient/endpoint"
+)
+
+func TestEndpointRegistry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	peer := amiclient.MessageFromMap(map[string]string{
+		"ObjectName": "pbx_sbc2_test", "IPaddress": "192.168.111.68", "IPport": "5060", "Status": "OK (5 ms)",
+	})
+
+	s := startTestServer(t, nil)
+	s.Handle("SIPpeers", amitest.List("PeerEntry", peer, amiclient.MessageFromMap(map[string]string{
+		"ObjectName": "sbc_incoming_test", "IPaddress": "-none-", "IPport": "0", "Dynamic": "yes", "Status": "UNKNOWN",
+	})))
+	s.Handle("PJSIPShowEndpoints", amitest.List("EndpointList", amiclient.MessageFromMap(map[string]string{
+		"ObjectName": "101", "Aor": "101", "DeviceState": "Not in use",
+	})))
+	s.Handle("PJSIPShowContacts", amitest.List("ContactList", amiclient.MessageFromMap(map[string]string{
+		"Uri": "sip:101@10.0.0.5:5060", "Aor": "101", "ViaAddr": "10.0.0.5", "ViaPort": "5060",
+		"Status": "Reachable", "RoundtripUsec": "1500",
+	})))
+
+	settings := s.ClientSettings()
+	settings.Reconnect = true
+	settings.ReconnectMinDelay = 10 * time.Millisecond
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	registry := endpoint.NewRegistry(client)
+
+	changes := make(chan endpoint.Change, 100)
+	registry.OnChange(func(c endpoint.Change) { changes <- c })
+
+	alerts := make(chan endpoint.Endpoint, 10)
+	registry.OnUnreachable(200*time.Millisecond, func(e endpoint.Endpoint) { alerts <- e })
+
+	err = registry.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Stop()
+
+	nextChange := func(name string, state endpoint.State) endpoint.Endpoint {
+		t.Helper()
+
+		for {
+			select {
+			case c := <-changes:
+				if c.Endpoint.Name != name {
+					continue
+				}
+
+				if c.Endpoint.State != state {
+					t.Fatalf("Expected %s %s, got %s", name, state, c.Endpoint.State)
+				}
+
+				return c.Endpoint
+			case <-ctx.Done():
+				t.Fatalf("No change of %s", name)
+			}
+		}
+	}
+
+	<-registry.Bootstrapped()
+
+	if ep, _ := registry.Endpoint("SIP/pbx_sbc2_test"); ep.State != endpoint.StateReachable ||
+		ep.Latency != 5*time.Millisecond || ep.Address != "192.168.111.68:5060" || !ep.Registered {
+		t.Errorf("Wrong SIP peer %+v", ep)
+	}
+
+	if ep, _ := registry.Endpoint("PJSIP/101"); ep.State != endpoint.StateReachable ||
+		ep.Latency != 1500*time.Microsecond || ep.Address != "10.0.0.5:5060" || len(ep.Contacts) != 1 {
+		t.Errorf("Wrong PJSIP endpoint %+v", ep)
+	}
+
+	// the unregistered dynamic peer is unreachable since the bootstrap
+	select {
+	case ep := <-alerts:
+		if ep.Name != "SIP/sbc_incoming_test" {
+			t.Errorf("Unexpected alert of %s", ep.Name)
+		}
+	case <-ctx.Done():
+		t.Fatal("No alert of the unregistered peer")
+	}
+
+	for len(changes) > 0 {
+		<-changes
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "PeerStatus", "ChannelType": "SIP", "Peer": "SIP/sbc_incoming_test", "PeerStatus": "Registered",
+		"Address": "192.168.111.4:5060",
+	}))
+	nextChange("SIP/sbc_incoming_test", endpoint.StateUnknown)
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "PeerStatus", "ChannelType": "SIP", "Peer": "SIP/pbx_sbc2_test", "PeerStatus": "Unreachable", "Time": "-1",
+	}))
+	nextChange("SIP/pbx_sbc2_test", endpoint.StateUnreachable)
+
+	// recovering before the alert duration cancels it
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "PeerStatus", "ChannelType": "SIP", "Peer": "SIP/pbx_sbc2_test", "PeerStatus": "Reachable", "Time": "7",
+	}))
+	ep := nextChange("SIP/pbx_sbc2_test", endpoint.StateReachable)
+
+	if ep.Latency != 7*time.Millisecond || !ep.UnreachableSince.IsZero() {
+		t.Errorf("Wrong recovered peer %+v", ep)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "ContactStatus", "URI": "sip:101@10.0.0.5:5060", "ContactStatus": "Unreachable", "AOR": "101", "RoundtripUsec": "0",
+	}))
+	nextChange("PJSIP/101", endpoint.StateUnreachable)
+
+	select {
+	case ep = <-alerts:
+		if ep.Name != "PJSIP/101" {
+			t.Errorf("Unexpected alert of %s", ep.Name)
+		}
+	case <-ctx.Done():
+		t.Fatal("No alert of the unreachable endpoint")
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "ContactStatus", "URI": "sip:101@10.0.0.6:5060", "ContactStatus": "Created", "AOR": "101", "RoundtripUsec": "0",
+	}))
+	nextChange("PJSIP/101", endpoint.StateUnknown)
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "ContactStatus", "URI": "sip:101@10.0.0.6:5060", "ContactStatus": "Reachable", "AOR": "101", "RoundtripUsec": "2000",
+	}))
+	ep = nextChange("PJSIP/101", endpoint.StateReachable)
+
+	if ep.Latency != 2*time.Millisecond || len(ep.Contacts) != 2 {
+		t.Errorf("Wrong endpoint with a new contact %+v", ep)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{"Event": "DeviceStateChange", "Device": "PJSIP/101", "State": "INUSE"}))
+	ep = nextChange("PJSIP/101", endpoint.StateReachable)
+
+	if ep.DeviceState != "INUSE" {
+		t.Errorf("Expected INUSE device state, got %q", ep.DeviceState)
+	}
+
+	if unreachable := registry.Unreachable(0); len(unreachable) != 0 {
+		t.Errorf("Expected no unreachable endpoints, got %v", unreachable)
+	}
+
+	select {
+	case ep = <-alerts:
+		t.Errorf("Unexpected alert of %s", ep.Name)
+	default:
+	}
+
+	// the peer and the contacts gone while the connection was down disappear, the new peer appears
+	s.Handle("SIPpeers", amitest.List("PeerEntry", peer, amiclient.MessageFromMap(map[string]string{
+		"ObjectName": "trunk", "IPaddress": "192.168.111.70", "IPport": "5060", "Status": "OK (3 ms)",
+	})))
+	s.Handle("PJSIPShowContacts", amitest.List("ContactList"))
+	s.Drop()
+
+	reloaded := make([]endpoint.Change, 0, 3)
+
+	for len(reloaded) < 3 {
+		select {
+		case c := <-changes:
+			if c.Event == nil {
+				reloaded = append(reloaded, c)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Reconnect bootstrap not applied, got %+v", reloaded)
+		}
+	}
+
+	if c := reloaded[0]; c.Endpoint.Name != "PJSIP/101" || c.Endpoint.State != endpoint.StateUnreachable ||
+		c.Previous != endpoint.StateReachable || len(c.Endpoint.Contacts) != 0 {
+		t.Errorf("Wrong reloaded endpoint %+v", c)
+	}
+
+	if c := reloaded[1]; c.Endpoint.Name != "SIP/sbc_incoming_test" || !c.Removed {
+		t.Errorf("Expected the removed peer, got %+v", c)
+	}
+
+	if c := reloaded[2]; c.Endpoint.Name != "SIP/trunk" || c.Endpoint.State != endpoint.StateReachable {
+		t.Errorf("Wrong new peer %+v", c)
+	}
+
+	if ep, _ := registry.Endpoint("SIP/pbx_sbc2_test"); ep.Latency != 5*time.Millisecond {
+		t.Errorf("Wrong reloaded peer %+v", ep)
+	}
+}
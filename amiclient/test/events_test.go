@@ -0,0 +1,243 @@
+package test_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/events"
+)
+
+type DecodeEventTC struct {
+	name           string
+	input          string
+	expectedResult events.Event
+	expectedErr    error
+}
+
+func TestDecode(t *testing.T) {
+	testCases := []DecodeEventTC{
+		{
+			name: "hangup normal clearing",
+			input: "Event: Hangup\nPrivilege: call,all\nChannel: Local/979144181775@phonenumber-checker-00000147;2\n" +
+				"ChannelState: 4\nChannelStateDesc: Ring\nCallerIDNum: <unknown>\nCallerIDName: <unknown>\n" +
+				"ConnectedLineNum: <unknown>\nConnectedLineName: <unknown>\nLanguage: en\n" +
+				"AccountCode: phonenumber-checker\nContext: phonenumber-checker\nExten: h\nPriority: 1\n" +
+				"Uniqueid: 1651218111.2444\nLinkedid: 1651218111.2443\ncause: 16\ncause-txt: Normal Clearing",
+			expectedResult: &events.Hangup{
+				Base: events.Base{Event: "Hangup", Privilege: "call,all"},
+				ChannelSnapshot: events.ChannelSnapshot{
+					Channel:           "Local/979144181775@phonenumber-checker-00000147;2",
+					ChannelState:      4,
+					ChannelStateDesc:  "Ring",
+					CallerIDNum:       "<unknown>",
+					CallerIDName:      "<unknown>",
+					ConnectedLineNum:  "<unknown>",
+					ConnectedLineName: "<unknown>",
+					Language:          "en",
+					AccountCode:       "phonenumber-checker",
+					Context:           "phonenumber-checker",
+					Exten:             "h",
+					Priority:          1,
+					Uniqueid:          "1651218111.2444",
+					Linkedid:          "1651218111.2443",
+				},
+				Cause:    16,
+				CauseTxt: "Normal Clearing",
+			},
+		},
+		{
+			name: "dial begin with destination",
+			input: "Event: DialBegin\nChannel: SIP/pbx_sbc2_test-00000001\nUniqueid: 1651218111.2443\n" +
+				"DestChannel: Local/979144181775@phonenumber-checker-00000147;1\nDestChannelState: 0\n" +
+				"DestUniqueid: 1651218111.2444\nDialString: 979144181775@phonenumber-checker",
+			expectedResult: &events.DialBegin{
+				Base: events.Base{Event: "DialBegin"},
+				ChannelSnapshot: events.ChannelSnapshot{
+					Channel:  "SIP/pbx_sbc2_test-00000001",
+					Uniqueid: "1651218111.2443",
+				},
+				Dest: events.ChannelSnapshot{
+					Channel:  "Local/979144181775@phonenumber-checker-00000147;1",
+					Uniqueid: "1651218111.2444",
+				},
+				DialString: "979144181775@phonenumber-checker",
+			},
+		},
+		{
+			name: "queue member status booleans",
+			input: "Event: QueueMemberStatus\nQueue: support\nMemberName: Agent 1\nInterface: SIP/1001\n" +
+				"Penalty: 2\nCallsTaken: 7\nInCall: 1\nStatus: 2\nPaused: 0\nRinginuse: yes",
+			expectedResult: &events.QueueMemberStatus{
+				Base:       events.Base{Event: "QueueMemberStatus"},
+				Queue:      "support",
+				MemberName: "Agent 1",
+				Interface:  "SIP/1001",
+				Penalty:    2,
+				CallsTaken: 7,
+				InCall:     true,
+				Status:     2,
+				Ringinuse:  true,
+			},
+		},
+		{
+			name: "attended transfer prefixes",
+			input: "Event: AttendedTransfer\nResult: Success\nOrigTransfererUniqueid: 1.1\n" +
+				"OrigBridgeUniqueid: b1\nSecondTransfererUniqueid: 2.1\nSecondBridgeUniqueid: b2\n" +
+				"DestType: Bridge\nDestBridgeUniqueid: b1\nIsExternal: No",
+			expectedResult: &events.AttendedTransfer{
+				Base:               events.Base{Event: "AttendedTransfer"},
+				Result:             "Success",
+				OrigTransferer:     events.ChannelSnapshot{Uniqueid: "1.1"},
+				OrigBridge:         events.BridgeSnapshot{BridgeUniqueid: "b1"},
+				SecondTransferer:   events.ChannelSnapshot{Uniqueid: "2.1"},
+				SecondBridge:       events.BridgeSnapshot{BridgeUniqueid: "b2"},
+				DestType:           "Bridge",
+				DestBridgeUniqueid: "b1",
+			},
+		},
+		{
+			name:        "unknown event",
+			input:       "Event: SomethingNew\nPrivilege: system,all",
+			expectedErr: events.ErrUnknownEvent,
+		},
+		{
+			name:        "not an event",
+			input:       "Response: Success\nMessage: Authentication accepted",
+			expectedErr: events.ErrNotEvent,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			buf.WriteString(tc.input)
+
+			res, err := events.Decode(amiclient.ParseMessage(buf))
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Expected error %v, got %v", tc.expectedErr, err)
+			}
+
+			if !reflect.DeepEqual(res, tc.expectedResult) {
+				t.Errorf("Wrong expected result\nresult:   %+v\nexpected: %+v", res, tc.expectedResult)
+			}
+		})
+	}
+}
+
+func TestDecode_InvalidValue(t *testing.T) {
+	e, err := events.Decode(amiclient.MessageFromMap(map[string]string{
+		"Event":        "Newstate",
+		"ChannelState": "up",
+		"Uniqueid":     "1651218111.2443",
+	}))
+	if !errors.Is(err, amiclient.ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader for non numeric ChannelState, got %v", err)
+	}
+
+	// the other headers are decoded
+	if e, ok := e.(*events.Newstate); !ok || e.Uniqueid != "1651218111.2443" || e.ChannelState != 0 {
+		t.Errorf("Wrong partially decoded event %+v", e)
+	}
+}
+
+func TestDecode_TestMessages(t *testing.T) {
+	file, err := fs.Open("data/test_messages.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(file)
+	peers := 0
+
+	for {
+		msg, err := amiclient.ReadMessage(reader)
+		if err != nil {
+			break
+		}
+
+		if !msg.Has("Event") {
+			continue
+		}
+
+		e, err := events.Decode(msg)
+		if err != nil {
+			t.Fatalf("Unable decode %v, %s", msg, err)
+		}
+
+		switch e := e.(type) {
+		case *events.FullyBooted:
+			if e.Status != "Fully Booted" {
+				t.Errorf("Wrong FullyBooted %+v", e)
+			}
+		case *events.PeerStatus:
+			peers++
+
+			if e.ChannelType != "SIP" || e.PeerStatus != "Registered" {
+				t.Errorf("Wrong PeerStatus %+v", e)
+			}
+		default:
+			t.Errorf("Unexpected event %T", e)
+		}
+	}
+
+	if peers != 2 {
+		t.Errorf("Expected 2 PeerStatus events, got %d", peers)
+	}
+}
+
+func TestHangupCause(t *testing.T) {
+	testCases := []struct {
+		cause       events.HangupCause
+		name        string
+		description string
+		category    events.CauseCategory
+		sip         int
+	}{
+		{events.CauseNormalClearing, "NORMAL_CLEARING", "Normal Clearing", events.CategorySuccess, 0},
+		{events.CauseUserBusy, "USER_BUSY", "User busy", events.CategoryBusy, 486},
+		{events.CauseNoAnswer, "NO_ANSWER", "User alerting, no answer", events.CategoryNoAnswer, 480},
+		{events.CauseUnallocated, "UNALLOCATED", "Unallocated (unassigned) number", events.CategoryInvalidNumber, 404},
+		{events.CauseNetworkOutOfOrder, "NETWORK_OUT_OF_ORDER", "Network out of order", events.CategoryNetworkFailure, 500},
+		{events.CauseNormalCircuitCongestion, "NORMAL_CIRCUIT_CONGESTION", "Circuit/channel congestion", events.CategoryCongestion, 503},
+		{events.CauseNormalUnspecified, "NORMAL_UNSPECIFIED", "Normal, unspecified", events.CategoryOther, 480},
+		{events.CauseCallRejected, "CALL_REJECTED", "Call Rejected", events.CategoryRejected, 403},
+		{events.HangupCause(250), "NOTDEFINED", "Unknown", events.CategoryOther, 0},
+	}
+
+	for _, tc := range testCases {
+		if tc.cause.Name() != tc.name || tc.cause.String() != tc.description ||
+			tc.cause.Category() != tc.category || tc.cause.SIPResponse() != tc.sip {
+			t.Errorf("Wrong cause %d: %s %q %s %d", tc.cause, tc.cause.Name(), tc.cause, tc.cause.Category(), tc.cause.SIPResponse())
+		}
+	}
+
+	sip := map[int]events.HangupCause{
+		404: events.CauseUnallocated,
+		486: events.CauseUserBusy,
+		480: events.CauseNoAnswer,
+		503: events.CauseNormalCircuitCongestion,
+		603: events.CauseCallRejected,
+		499: events.CauseInterworking,
+		599: events.CauseNormalCircuitCongestion,
+	}
+
+	for code, expected := range sip {
+		if cause := events.CauseFromSIP(code); cause != expected {
+			t.Errorf("SIP %d: expected %s, got %s", code, expected.Name(), cause.Name())
+		}
+	}
+
+	e, err := events.Decode(amiclient.Message{{Key: "Event", Value: "Hangup"}, {Key: "Cause", Value: "17"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h := e.(*events.Hangup); h.Cause != events.CauseUserBusy || h.Cause.Category() != events.CategoryBusy {
+		t.Errorf("Wrong decoded cause %d", h.Cause)
+	}
+}
@@ -0,0 +1,89 @@
+package test_test
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Arten331/telephony/amiclient"
+)
+
+//nolint:gochecknoglobals // fuzz seed corpus
+var fuzzSeeds = []string{
+	"Response: Success\nMessage: Authentication accepted\n\n",
+	"Response: Error\nMessage: Error: channel not found\n\n",
+	"Event:Hangup\r\nCause :16\r\n\r\n",
+	"Action: Ping\nActionID\n\n",
+	"Response: Follows\nPrivilege: Command\nline: one\n\n--END COMMAND--\n\n",
+	":\n: \n \n\n",
+}
+
+// go test -run xxx -fuzz FuzzParseMessage ./amiclient/test
+func FuzzParseMessage(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := amiclient.ParseMessage(*bytes.NewBuffer(data))
+
+		// parsing the serialized result gives the same headers
+		again := amiclient.ParseMessage(*bytes.NewBuffer(msg.Serialize()))
+		if len(msg) != 0 && !reflect.DeepEqual(msg, again) {
+			t.Errorf("Parse is not stable, %q != %q", msg, again)
+		}
+
+		strict, err := amiclient.ParseMessageStrict(*bytes.NewBuffer(data))
+		if err == nil && !reflect.DeepEqual(strict, msg) {
+			t.Errorf("Strict result %q differs from %q", strict, msg)
+		}
+	})
+}
+
+func FuzzParseAction(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		action := amiclient.ParseAction(*bytes.NewBuffer(data))
+
+		for _, h := range action {
+			if strings.ContainsRune(h.Key, '\n') || strings.ContainsRune(h.Value, '\n') {
+				t.Errorf("Line terminator inside header %q", h)
+			}
+		}
+	})
+}
+
+func FuzzReadMessage(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s), false)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, strict bool) {
+		parser := amiclient.NewParser(bufio.NewReaderSize(bytes.NewReader(data), 16))
+		parser.Strict = strict
+
+		for i := 0; i <= len(data); i++ {
+			msg, err := parser.Next()
+			if err != nil {
+				if strict {
+					continue
+				}
+
+				break
+			}
+
+			for _, h := range msg {
+				if strings.ContainsRune(h.Key, '\n') || strings.ContainsAny(h.Value, "\n") {
+					t.Errorf("Line terminator inside header %q", h)
+				}
+			}
+		}
+
+		_, _ = amiclient.ReadMessage(bufio.NewReader(bytes.NewReader(data)))
+	})
+}
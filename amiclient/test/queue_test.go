@@ -0,0 +1,212 @@
+package test_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/amitest"
+	"github.com/Arten331/telephony/amiclient/queue"
+)
+
+func TestQueueTracker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	s.Handle("QueueSummary", amitest.List("QueueSummary",
+		amiclient.MessageFromMap(map[string]string{"Queue": "support", "HoldTime": "12"}),
+	))
+	s.Handle("QueueStatus", amitest.ListEvents("QueueStatusComplete",
+		amiclient.MessageFromMap(map[string]string{
+			"Event": "QueueParams", "Queue": "support", "Strategy": "ringall", "Holdtime": "20", "Completed": "7", "Abandoned": "2",
+		}),
+		amiclient.MessageFromMap(map[string]string{
+			"Event": "QueueMember", "Queue": "support", "Name": "Alice", "Location": "PJSIP/101", "Status": "1", "Paused": "0",
+		}),
+		amiclient.MessageFromMap(map[string]string{
+			"Event": "QueueMember", "Queue": "support", "Name": "Bob", "Location": "PJSIP/102", "Status": "1", "Paused": "1",
+		}),
+		amiclient.MessageFromMap(map[string]string{
+			"Event": "QueueEntry", "Queue": "support", "Channel": "SIP/trunk-1.1", "Uniqueid": "1.1", "Position": "1", "Wait": "30",
+		}),
+	))
+
+	settings := s.ClientSettings()
+	settings.Reconnect = true
+	settings.ReconnectMinDelay = 10 * time.Millisecond
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	tracker := queue.NewTracker(client, "")
+
+	changes := make(chan queue.Change, 100)
+	tracker.OnChange(func(c queue.Change) { changes <- c })
+
+	err = tracker.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Stop()
+
+	nextChange := func(event string) queue.Queue {
+		t.Helper()
+
+		select {
+		case c := <-changes:
+			name := ""
+			if c.Event != nil {
+				name = c.Event.EventName()
+			}
+
+			if name != event {
+				t.Fatalf("Expected change by %q, got %q", event, name)
+			}
+
+			return c.Queue
+		case <-ctx.Done():
+			t.Fatalf("No change by %q", event)
+		}
+
+		return queue.Queue{}
+	}
+
+	q := nextChange("")
+	<-tracker.Bootstrapped()
+
+	if q.Name != "support" || q.Strategy != "ringall" || q.Completed != 7 || q.Abandoned != 2 ||
+		q.HoldTime != 20*time.Second || len(q.Members) != 2 || q.Paused() != 1 || q.Available() != 1 {
+		t.Errorf("Wrong bootstrapped queue %+v", q)
+	}
+
+	if len(q.Callers) != 1 || q.LongestWait() < 30*time.Second {
+		t.Errorf("Wrong waiting callers %+v", q.Callers)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "QueueCallerJoin", "Queue": "support", "Channel": "SIP/trunk-1.2", "Uniqueid": "1.2", "Position": "2",
+	}))
+	q = nextChange("QueueCallerJoin")
+
+	if len(q.Callers) != 2 || q.Callers[1].Uniqueid != "1.2" {
+		t.Errorf("Caller did not join %+v", q.Callers)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "QueueCallerAbandon", "Queue": "support", "Channel": "SIP/trunk-1.1", "Uniqueid": "1.1", "Position": "1",
+	}))
+	q = nextChange("QueueCallerAbandon")
+
+	if q.Abandoned != 3 {
+		t.Errorf("Expected 3 abandoned calls, got %d", q.Abandoned)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "QueueCallerLeave", "Queue": "support", "Channel": "SIP/trunk-1.1", "Uniqueid": "1.1", "Position": "1",
+	}))
+	q = nextChange("QueueCallerLeave")
+
+	if len(q.Callers) != 1 || q.Callers[0].Uniqueid != "1.2" || q.Callers[0].Position != 1 {
+		t.Errorf("Callers not moved up %+v", q.Callers)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "QueueMemberPause", "Queue": "support", "Interface": "PJSIP/101", "MemberName": "Alice",
+		"Penalty": "1", "Status": "1", "Paused": "1",
+	}))
+	q = nextChange("QueueMemberPause")
+
+	if m, _ := q.Member("PJSIP/101"); !m.Paused || q.Available() != 0 {
+		t.Errorf("Member not paused %+v", m)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "QueueMemberAdded", "Queue": "support", "Interface": "PJSIP/103", "MemberName": "Carol",
+		"Penalty": "1", "Status": "1", "Paused": "0",
+	}))
+	nextChange("QueueMemberAdded")
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "AgentConnect", "Queue": "support", "Channel": "SIP/trunk-1.2", "Uniqueid": "1.2",
+		"Interface": "PJSIP/103", "HoldTime": "4",
+	}))
+	q = nextChange("AgentConnect")
+
+	if m, _ := q.Member("PJSIP/103"); !m.InCall || q.HoldTime != 16*time.Second {
+		t.Errorf("Wrong connected queue %+v", q)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "AgentComplete", "Queue": "support", "Channel": "SIP/trunk-1.2", "Uniqueid": "1.2",
+		"Interface": "PJSIP/103", "TalkTime": "60",
+	}))
+	q = nextChange("AgentComplete")
+
+	if m, _ := q.Member("PJSIP/103"); m.InCall || q.Completed != 8 {
+		t.Errorf("Wrong completed queue %+v", q)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "QueueMemberRemoved", "Queue": "support", "Interface": "PJSIP/102", "MemberName": "Bob",
+	}))
+	q = nextChange("QueueMemberRemoved")
+
+	if _, ok := q.Member("PJSIP/102"); ok || len(q.Members) != 2 {
+		t.Errorf("Member not removed %+v", q.Members)
+	}
+
+	gauges := queueGauges(t, tracker)
+
+	if gauges["ami_queue_members"] != 2 || gauges["ami_queue_members_paused"] != 1 ||
+		gauges["ami_queue_abandoned"] != 3 || gauges["ami_queue_member_paused"] != 1 {
+		t.Errorf("Wrong gauges %v", gauges)
+	}
+
+	// the member removed and the caller gone while the connection was down disappear
+	s.Handle("QueueSummary", amitest.List("QueueSummary"))
+	s.Handle("QueueStatus", amitest.ListEvents("QueueStatusComplete",
+		amiclient.MessageFromMap(map[string]string{"Event": "QueueParams", "Queue": "support", "Completed": "9", "Abandoned": "3"}),
+		amiclient.MessageFromMap(map[string]string{
+			"Event": "QueueMember", "Queue": "support", "Name": "Alice", "Location": "PJSIP/101", "Status": "1", "Paused": "1",
+		}),
+	))
+	s.Drop()
+
+	q = nextChange("")
+
+	if len(q.Members) != 1 || len(q.Callers) != 0 || q.Completed != 9 {
+		t.Errorf("Wrong reloaded queue %+v", q)
+	}
+}
+
+// queueGauges sums the gauges of the tracker by name.
+func queueGauges(t *testing.T, tracker *queue.Tracker) map[string]float64 {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(tracker.GetMetrics()...)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := make(map[string]float64)
+
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			res[family.GetName()] += m.GetGauge().GetValue()
+		}
+	}
+
+	return res
+}
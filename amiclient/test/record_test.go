@@ -0,0 +1,182 @@
+package test_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/amitest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	output := []string{"System uptime: 1 hour", "", "Last reload: 1 hour"}
+
+	s := startTestServer(t, nil)
+	s.Handle("Command", amitest.Respond(amiclient.Message{
+		{Key: "Response", Value: "Follows"},
+		{Key: "Privilege", Value: "Command"},
+		{Key: "Output", Value: output[0]},
+		{Key: "Output", Value: output[1]},
+		{Key: "Output", Value: output[2]},
+	}))
+
+	var session bytes.Buffer
+
+	settings := s.ClientSettings()
+	settings.Recorder = amiclient.NewRecorder(&session)
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Do(ctx, amiclient.NewAction("Ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Command(ctx, "core show uptime")
+	if err != nil || !reflect.DeepEqual(res.Output, output) {
+		t.Fatalf("Wrong command output %q, %v", res.Output, err)
+	}
+
+	sub, err := client.Subscribe(amiclient.Filter{Events: []string{"Hangup"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Emit(amiclient.Message{{Key: "Event", Value: "Hangup"}, {Key: "Cause", Value: "16"}})
+	<-sub.C()
+
+	_ = client.Close(ctx)
+
+	if err = settings.Recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := amiclient.ReadRecords(&session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var directions []amiclient.Direction
+
+	for _, r := range records {
+		directions = append(directions, r.Direction)
+
+		if r.Time.IsZero() {
+			t.Errorf("Record without time %v", r)
+		}
+	}
+
+	if len(records) < 7 || records[0].Message.Get("Action") != "Login" || records[0].Message.Get("Secret") == "test" {
+		t.Fatalf("Wrong session %v", records)
+	}
+
+	hangup, follows := 0, 0
+
+	for _, r := range records {
+		if r.Direction == amiclient.DirectionIn && r.Message.Get("Event") == "Hangup" {
+			hangup++
+		}
+
+		// the command body must not swallow the records after it
+		if r.Message.Get("Response") == "Follows" {
+			follows++
+
+			if !reflect.DeepEqual(r.Message.GetAll("Output"), output) || r.Message.Get("ActionID") == "" {
+				t.Fatalf("Wrong recorded command response %v", r.Message)
+			}
+		}
+	}
+
+	if hangup != 1 || follows != 1 || directions[0] != amiclient.DirectionOut || directions[1] != amiclient.DirectionIn {
+		t.Fatalf("Wrong session, %d hangups, %d command responses, directions %v", hangup, follows, directions)
+	}
+
+	// directly into the dispatcher of a client without connection
+	offline := amiclient.New(&amiclient.Settings{DisableMsgChan: true})
+
+	sub, err = offline.Subscribe(amiclient.Filter{Events: []string{"Hangup"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = offline.Replay(ctx, records, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := <-sub.C(); msg.Get("Cause") != "16" {
+		t.Errorf("Wrong replayed event %v", msg)
+	}
+
+	// through the fake server
+	replay := startTestServer(t, nil)
+
+	client = amiclient.New(replay.ClientSettings())
+
+	err = client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	sub, err = client.Subscribe(amiclient.Filter{Events: []string{"Hangup"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = replay.Replay(ctx, records, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-sub.C():
+		if msg.Get("Cause") != "16" {
+			t.Errorf("Wrong replayed event %v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("Replayed event not received")
+	}
+}
+
+func TestReplay_Speed(t *testing.T) {
+	start := time.Now()
+	records := []amiclient.Record{
+		{Time: start, Direction: amiclient.DirectionIn},
+		{Time: start.Add(200 * time.Millisecond), Direction: amiclient.DirectionIn},
+	}
+
+	replayed := 0
+
+	err := amiclient.Replay(context.Background(), records, 2, func(amiclient.Record) { replayed++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); replayed != 2 || elapsed < 100*time.Millisecond {
+		t.Errorf("Wrong replay, %d records in %s", replayed, elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err = amiclient.Replay(ctx, records, 1, func(amiclient.Record) {}); err == nil {
+		t.Error("Expected canceled replay")
+	}
+
+	_, err = amiclient.ReadRecords(bytes.NewBufferString("Event: Hangup\n\n"))
+	if err == nil {
+		t.Error("Expected error for session without record lines")
+	}
+}
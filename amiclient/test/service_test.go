@@ -3,179 +3,212 @@ package test_test
 import (
 	"bufio"
 	"bytes"
-	"context"
 	"embed"
-	"fmt"
-	"io"
-	"net"
 	"strconv"
-
-	_ "embed"
+	"strings"
+	"testing"
 
 	"github.com/Arten331/telephony/amiclient"
-
-	"github.com/Arten331/observability/logger"
+	"github.com/Arten331/telephony/amiclient/amitest"
 )
 
+const testBanner = "Asterisk Call Manager/2.10.5"
+
 //go:embed data/*
 var fs embed.FS
 
-func StartTestTCPServer(ctx context.Context, port int, enableLog bool) net.Listener {
-	c, _ := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
-
-	logger.S().Info("Started test TCP server %s", c.Addr())
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				err := c.Close()
-				if err != nil {
-					logger.S().Infof("Unable stop TCP server: %s", err.Error())
-				}
-
-				logger.S().Infof("Stopped test TCP server %s", c.Addr())
+// startTestServer starts the fake server with the actions used by the client tests:
+// GiveMeTest sends data/test_messages.txt (Count times / 4 + 1), TestSession reports the
+// event mask and the filters of the connection, MutePing silences Ping and Drop closes the connection.
+func startTestServer(tb testing.TB, cfg *amitest.Settings) *amitest.Server {
+	tb.Helper()
 
-				return
-			default:
-				conn, err := c.Accept()
-				if err != nil || conn == nil {
-					continue
-				}
+	var settings amitest.Settings
+	if cfg != nil {
+		settings = *cfg
+	}
 
-				go handleConnection(conn, enableLog)
-			}
-		}
-	}()
+	if settings.Banner == "" {
+		settings.Banner = testBanner
+	}
 
-	return c
-}
+	s, err := amitest.NewServer(&settings)
+	if err != nil {
+		tb.Fatal(err)
+	}
 
-func handleConnection(conn net.Conn, enableLog bool) {
-	var (
-		message amiclient.Message
-		err     error
-	)
+	tb.Cleanup(func() { _ = s.Close() })
 
-	defer func(conn net.Conn) {
-		_ = conn.Close()
-	}(conn)
+	testMessages := readTestMessages(tb)
 
-	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	s.Handle("GiveMeTest", func(c *amitest.Conn, action amiclient.Action) {
+		cnt := 1
 
-	for {
-		message, err = readMessage(rw.Reader)
-		if err != nil {
-			_, _ = rw.WriteString("failed to read input")
+		if count, ok := action.Lookup("Count"); ok {
+			cnt, _ = strconv.Atoi(count)
+			cnt = cnt/4 + 1
+		}
 
-			_ = rw.Flush()
+		last := len(testMessages) - 1
 
+		for i := 0; i < cnt; i++ {
+			_ = c.Send(testMessages[:last]...)
+			c.Respond(action, testMessages[last])
+		}
+	})
+	s.Handle("Drop", amitest.DropConnection)
+	s.Handle("NoAnswer", amitest.NoResponse)
+	s.Handle("MutePing", amitest.Respond(amiclient.Message{{Key: "Response", Value: "Success"}}))
+	s.Handle("Ping", func(c *amitest.Conn, action amiclient.Action) {
+		if testSession(c).mute {
 			return
 		}
 
-		answer := getAnswer(message)
-
-		// For bench section
-		_, testCnt := message["Count"]
-		if message["Action"] == "GiveMeTest" && testCnt {
-			_, ok := message["Count"]
-			if ok {
-				cnt, _ := strconv.Atoi(message["Count"])
-				cnt /= 4
-				cnt++
+		c.Respond(action, amiclient.Message{
+			{Key: "Response", Value: "Success"},
+			{Key: "Ping", Value: "Pong"},
+			{Key: "Timestamp", Value: "1651218111.244400"},
+		})
+	})
+	s.Handle("Filter", func(c *amitest.Conn, action amiclient.Action) {
+		if strings.ContainsAny(action.Get("Filter"), "()") {
+			c.Respond(action, amitest.Error("Filter Not Added"))
 
-				for {
-					cnt--
-
-					_, _ = rw.WriteString(fmt.Sprintf("\n%s\n", answer))
-					_ = rw.Flush()
-
-					if cnt == 0 {
-						return
-					}
-				}
-			}
+			return
 		}
 
-		if enableLog {
-			logger.S().Infof("received: \n%s", serializeMessage(message))
-			logger.S().Infof("answer: \n%s", answer)
+		c.Respond(action, amitest.Success("Filter Added Successfully"))
+	})
+	s.Handle("TestSession", func(c *amitest.Conn, action amiclient.Action) {
+		session := testSession(c)
+
+		response := amiclient.Message{{Key: "Response", Value: "Success"}, {Key: "Events", Value: session.events}}
+		for _, f := range session.filters {
+			response.Add("Filter", f)
 		}
 
-		_, _ = rw.WriteString(fmt.Sprintf("\n%s\n", answer))
-		_ = rw.Flush()
-	}
+		c.Respond(action, response)
+	})
+	s.Handle("Getvar", func(c *amitest.Conn, action amiclient.Action) {
+		c.Respond(action, amiclient.Message{
+			{Key: "Response", Value: "Success"},
+			{Key: "Variable", Value: action.Get("Variable")},
+			{Key: "Value", Value: "979144181775"},
+		})
+	})
+	s.Handle("Command", command)
+	s.Handle("CoreShowChannels", coreShowChannels)
+
+	return s
 }
 
-func getAnswer(message amiclient.Message) []byte {
-	var answer bytes.Buffer
-
-	_, ok := message["Action"]
-
-	if !ok {
-		_, _ = answer.WriteString("Response: Error\nMessage: Missing action in request")
+func readTestMessages(tb testing.TB) []amiclient.Message {
+	tb.Helper()
 
-		return answer.Bytes()
+	data, err := fs.ReadFile("data/test_messages.txt")
+	if err != nil {
+		tb.Fatal(err)
 	}
 
-	switch message["Action"] {
-	case "Login":
-		if message["Username"] == "BadGuy" || message["Secret"] == "BadGuy" {
-			_, _ = answer.WriteString("Response: Error\nMessage: Authentication failed")
-		}
+	var msgs []amiclient.Message
+
+	parser := amiclient.NewParser(bufio.NewReader(bytes.NewReader(data)))
 
-		_, _ = answer.WriteString("Response: Success\nMessage: Authentication accepted")
-	case "GiveMeTest":
-		file, err := fs.Open("data/test_messages.txt")
+	for {
+		msg, err := parser.Next()
 		if err != nil {
-			panic(err)
+			return msgs
 		}
 
-		msg, _ := io.ReadAll(file)
-
-		_, _ = answer.Write(msg)
-	default:
-		_, _ = answer.WriteString("Response: Error\nMessage: Permission denied")
+		msgs = append(msgs, msg.Clone())
 	}
-
-	return answer.Bytes()
 }
 
-func serializeMessage(m map[string]string) []byte {
-	var command bytes.Buffer
+// session is the state of a connection set by Login, Events, Filter and MutePing.
+type session struct {
+	events  string
+	filters []string
+	mute    bool
+}
 
-	for key := range m {
-		command.WriteString(key)
-		command.Write([]byte{':', ' '})
-		command.WriteString(m[key])
-		command.Write([]byte{'\n'})
+func testSession(c *amitest.Conn) session {
+	var res session
+
+	for _, action := range c.Received() {
+		switch action.Get("Action") {
+		case "Login":
+			res.events = action.Get("Events")
+		case "Events":
+			res.events = action.Get("EventMask")
+		case "Filter":
+			if !strings.ContainsAny(action.Get("Filter"), "()") {
+				res.filters = append(res.filters, action.Get("Filter"))
+			}
+		case "MutePing":
+			res.mute = true
+		}
 	}
 
-	return command.Bytes()
+	return res
 }
 
-func readMessage(r *bufio.Reader) (amiclient.Message, error) {
-	var (
-		buf      bytes.Buffer
-		isPrefix bool
-		err      error
-		line     []byte
-	)
-
-	for {
-		line, isPrefix, err = r.ReadLine()
-		if line == nil || len(line) <= 1 {
-			break
-		}
-
-		buf.Write(line)
+func command(c *amitest.Conn, action amiclient.Action) {
+	switch name := action.Get("Command"); name {
+	case "core show channels":
+		c.Respond(action, amiclient.Message{
+			{Key: "Response", Value: "Follows"},
+			{Key: "Privilege", Value: "Command"},
+			{Key: "Output", Value: "Channel              Location             State   Application(Data)"},
+			{Key: "Output", Value: "SIP/pbx_sbc2_test-00000001 979144181775@phonenumber-checker Up Dial(Local/979144181775)"},
+			{Key: "Output", Value: ""},
+			{Key: "Output", Value: "Uptime: 1 hour, 5 minutes"},
+			{Key: "Output", Value: "1 active channel"},
+		})
+	case "core show uptime":
+		c.Respond(action, amiclient.Message{
+			{Key: "Response", Value: "Success"},
+			{Key: "Message", Value: "Command output follows"},
+			{Key: "Output", Value: "System uptime: 1 hour, 5 minutes"},
+			{Key: "Output", Value: "Last reload: 1 hour, 5 minutes"},
+		})
+	default:
+		c.Respond(action, amiclient.Message{
+			{Key: "Response", Value: "Error"},
+			{Key: "Message", Value: "Command output follows"},
+			{Key: "Output", Value: "No such command '" + name + "' (type 'core show help " + name + "' for other possible commands)"},
+		})
+	}
+}
 
-		if !isPrefix {
-			buf.Write([]byte{'\n'})
-		}
+// coreShowChannels lists two channels with an unrelated FullyBooted event after each item.
+func coreShowChannels(c *amitest.Conn, action amiclient.Action) {
+	id := action.Get("ActionID")
+	items := []amiclient.Message{
+		{{Key: "Channel", Value: "SIP/pbx_sbc2_test-00000001"}, {Key: "Uniqueid", Value: "1651218111.2443"}},
+		{{Key: "Channel", Value: "Local/979144181775@phonenumber-checker-00000147;2"}, {Key: "Uniqueid", Value: "1651218111.2444"}},
 	}
 
-	msg := amiclient.ParseMessage(buf)
+	c.Respond(action, amiclient.Message{
+		{Key: "Response", Value: "Success"},
+		{Key: "EventList", Value: "start"},
+		{Key: "Message", Value: "Channels will follow"},
+	})
+
+	for _, item := range items {
+		event := append(amiclient.Message{{Key: "Event", Value: "CoreShowChannel"}, {Key: "ActionID", Value: id}}, item...)
+		event.Add("Linkedid", "1651218111.2443")
+
+		_ = c.Send(event, amiclient.Message{
+			{Key: "Event", Value: "FullyBooted"},
+			{Key: "Privilege", Value: "system,all"},
+			{Key: "Status", Value: "Fully Booted"},
+		})
+	}
 
-	return msg, err
+	_ = c.Send(amiclient.Message{
+		{Key: "Event", Value: "CoreShowChannelComplete"},
+		{Key: "EventList", Value: "Complete"},
+		{Key: "ListItems", Value: strconv.Itoa(len(items))},
+		{Key: "ActionID", Value: id},
+	})
 }
@@ -0,0 +1,199 @@
+package test_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient"
+	"github.com/Arten331/telephony/amiclient/amitest"
+	"github.com/Arten331/telephony/amiclient/state"
+)
+
+func TestTracker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	s.Handle("CoreShowChannels", amitest.List("CoreShowChannel",
+		amiclient.MessageFromMap(map[string]string{
+			"Channel": "SIP/peer-1.1", "ChannelState": "6", "Uniqueid": "1.1", "Linkedid": "1.1", "Application": "Dial",
+		}),
+	))
+
+	settings := s.ClientSettings()
+	settings.Reconnect = true
+	settings.ReconnectMinDelay = 10 * time.Millisecond
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	tracker := state.NewTracker(client)
+
+	changes := make(chan state.Change, 100)
+	tracker.OnChange(func(c state.Change) { changes <- c })
+
+	err = tracker.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Stop()
+
+	nextChange := func(expected state.ChangeType, uniqueid string) state.Change {
+		t.Helper()
+
+		select {
+		case c := <-changes:
+			if c.Type != expected || c.Channel.Uniqueid != uniqueid {
+				t.Fatalf("Expected %s of %s, got %s of %s", expected, uniqueid, c.Type, c.Channel.Uniqueid)
+			}
+
+			return c
+		case <-ctx.Done():
+			t.Fatalf("No %s change of %s", expected, uniqueid)
+		}
+
+		return state.Change{}
+	}
+
+	nextChange(state.ChannelAdded, "1.1")
+	<-tracker.Bootstrapped()
+
+	if ch, ok := tracker.Channel("1.1"); !ok || ch.Application != "Dial" {
+		t.Errorf("Bootstrapped channel missing %+v", ch)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Newchannel", "Channel": "SIP/peer-1.2", "Uniqueid": "1.2", "Linkedid": "1.1",
+		"ChannelState": "0",
+	}))
+	nextChange(state.ChannelAdded, "1.2")
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Newstate", "Channel": "SIP/peer-1.2", "Uniqueid": "1.2", "Linkedid": "1.1",
+		"ChannelState": "6",
+	}))
+	nextChange(state.ChannelUpdated, "1.2")
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "VarSet", "Channel": "SIP/peer-1.2", "Uniqueid": "1.2", "Linkedid": "1.1",
+		"ChannelState": "6", "Variable": "DIALSTATUS", "Value": "ANSWER",
+	}))
+	nextChange(state.ChannelUpdated, "1.2")
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Rename", "Channel": "SIP/peer-1.2", "Uniqueid": "1.2", "Linkedid": "1.1",
+		"ChannelState": "6", "Newname": "SIP/renamed",
+	}))
+	nextChange(state.ChannelUpdated, "1.2")
+
+	ch, ok := tracker.ChannelByName("SIP/renamed")
+	if !ok || ch.ChannelState != 6 || ch.Variables["DIALSTATUS"] != "ANSWER" {
+		t.Errorf("Wrong channel %+v", ch)
+	}
+
+	if linked := tracker.Linked("1.1"); len(linked) != 2 {
+		t.Errorf("Expected 2 linked channels, got %v", linked)
+	}
+
+	s.Emit(amiclient.MessageFromMap(map[string]string{
+		"Event": "Hangup", "Channel": "SIP/peer-1.2", "Uniqueid": "1.2", "Linkedid": "1.1",
+		"ChannelState": "6", "Cause": "16",
+	}))
+	nextChange(state.ChannelRemoved, "1.2")
+
+	if _, ok = tracker.Channel("1.2"); ok || tracker.Len() != 1 || len(tracker.Linked("1.1")) != 1 {
+		t.Errorf("Channel not removed, %v", tracker.Channels())
+	}
+
+	// the channel hung up while the connection was down disappears from the list
+	s.Handle("CoreShowChannels", amitest.List("CoreShowChannel"))
+	s.Drop()
+
+	nextChange(state.ChannelRemoved, "1.1")
+
+	if tracker.Len() != 0 || len(tracker.Linked("1.1")) != 0 {
+		t.Errorf("Stale channels left, %v", tracker.Channels())
+	}
+}
+
+func TestTracker_EventsDuringBootstrap(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	channel := func(event, uniqueid, state string) amiclient.Message {
+		return amiclient.MessageFromMap(map[string]string{
+			"Event": event, "Channel": "SIP/peer-" + uniqueid, "ChannelState": state, "Uniqueid": uniqueid, "Linkedid": uniqueid,
+		})
+	}
+
+	s := startTestServer(t, nil)
+
+	// the events before the response are older than the list, the events after it are newer
+	s.Handle("CoreShowChannels", func(c *amitest.Conn, action amiclient.Action) {
+		item := func(uniqueid, state string) amiclient.Message {
+			msg := channel("CoreShowChannel", uniqueid, state)
+			msg.Add("ActionID", action.Get("ActionID"))
+
+			return msg
+		}
+
+		_ = c.Send(channel("Newchannel", "3.1", "0"), channel("Newstate", "3.1", "4"))
+		c.Respond(action, amiclient.Message{{Key: "Response", Value: "Success"}, {Key: "EventList", Value: "start"}})
+		_ = c.Send(
+			item("3.1", "6"),
+			channel("Newchannel", "3.2", "0"),
+			item("3.3", "6"),
+			channel("Hangup", "3.3", "6"),
+			amiclient.Message{
+				{Key: "Event", Value: "CoreShowChannelsComplete"},
+				{Key: "ActionID", Value: action.Get("ActionID")},
+				{Key: "EventList", Value: "Complete"},
+				{Key: "ListItems", Value: "2"},
+			},
+		)
+	})
+
+	client := amiclient.New(s.ClientSettings())
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	tracker := state.NewTracker(client)
+
+	err = tracker.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Stop()
+
+	select {
+	case <-tracker.Bootstrapped():
+	case <-ctx.Done():
+		t.Fatal("Tracker not bootstrapped")
+	}
+
+	if ch, ok := tracker.Channel("3.1"); !ok || ch.ChannelState != 6 {
+		t.Errorf("Channel state not loaded from the list %+v", ch)
+	}
+
+	if _, ok := tracker.Channel("3.2"); !ok {
+		t.Error("Channel created during the list removed")
+	}
+
+	if ch, ok := tracker.Channel("3.3"); ok {
+		t.Errorf("Channel hung up during the list is back %+v", ch)
+	}
+
+	if tracker.Len() != 2 {
+		t.Errorf("Wrong channels %v", tracker.Channels())
+	}
+}
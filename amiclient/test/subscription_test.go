@@ -0,0 +1,313 @@
+package test_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Arten331/telephony/amiclient"
+)
+
+func TestClient_Subscribe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	settings := s.ClientSettings()
+	settings.DisableMsgChan = true
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the FullyBooted sent after the login is dispatched before the pong
+	_, err = client.Do(ctx, amiclient.NewAction("Ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := client.Subscribe(amiclient.Filter{Events: []string{"peerstatus"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sbc, err := client.Subscribe(amiclient.Filter{
+		Headers: map[string]string{"Peer": "SIP/pbx_sbc2_test"},
+		Match: func(msg amiclient.Message) bool {
+			return msg.Get("PeerStatus") == "Registered"
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var all int32
+
+	everything, err := client.SubscribeFunc(amiclient.Filter{}, func(amiclient.Message) {
+		atomic.AddInt32(&all, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.SendCommand(amiclient.NewAction("GiveMeTest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-peers.C():
+			if msg.Get("Event") != "PeerStatus" {
+				t.Errorf("Wrong event for name filter, %v", msg)
+			}
+		case <-ctx.Done():
+			t.Fatal("PeerStatus events not come back")
+		}
+	}
+
+	select {
+	case msg := <-sbc.C():
+		if msg.Get("Address") != "192.168.111.68:5060" {
+			t.Errorf("Wrong event for header filter, %v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("Filtered event not come back")
+	}
+
+	select {
+	case msg := <-sbc.C():
+		t.Errorf("Unexpected event for header filter, %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if n := atomic.LoadInt32(&all); n != 4 {
+		t.Errorf("Expected 4 messages for callback, got %d", n)
+	}
+
+	peers.Unsubscribe()
+	peers.Unsubscribe()
+
+	if _, ok := <-peers.C(); ok {
+		t.Error("Channel not closed on Unsubscribe")
+	}
+
+	everything.Unsubscribe()
+	sbc.Unsubscribe()
+}
+
+func TestClient_ServerFilters(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	settings := s.ClientSettings()
+	settings.Reconnect = true
+	settings.ReconnectMinDelay = 10 * time.Millisecond
+	settings.EventMask = "call"
+	settings.Filters = []string{"Event: Hangup"}
+
+	client := amiclient.New(settings)
+
+	connected := make(chan struct{}, 2)
+	client.OnStateChange(func(state amiclient.ConnectionState) {
+		if state == amiclient.StateConnected {
+			connected <- struct{}{}
+		}
+	})
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-connected
+
+	checkSession := func(expectedEvents string, expectedFilters []string) {
+		t.Helper()
+
+		msg, err := client.Do(ctx, amiclient.NewAction("TestSession"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Get("Events") != expectedEvents || !reflect.DeepEqual(msg.GetAll("Filter"), expectedFilters) {
+			t.Errorf("Wrong session state %v, expected events %q, filters %q", msg, expectedEvents, expectedFilters)
+		}
+	}
+
+	checkSession("call", []string{"Event: Hangup"})
+
+	err = client.AddFilter(ctx, "Event: Newstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.SetEventMask(ctx, "call,system")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.AddFilter(ctx, "Event: (")
+	if !errors.Is(err, amiclient.ErrActionFailed) {
+		t.Errorf("Expected ErrActionFailed for broken filter, got %v", err)
+	}
+
+	checkSession("call,system", []string{"Event: Hangup", "Event: Newstate"})
+
+	err = client.SendCommand(amiclient.NewAction("Drop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-connected:
+	case <-ctx.Done():
+		t.Fatal("Client not reconnected")
+	}
+
+	checkSession("call,system", []string{"Event: Hangup", "Event: Newstate"})
+}
+
+func TestClient_SubscribeWithoutMsgChan(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	settings := s.ClientSettings()
+	settings.DisableMsgChan = true
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	var received int32
+
+	sub, err := client.SubscribeFunc(amiclient.Filter{Events: []string{"UserEvent"}}, func(amiclient.Message) {
+		atomic.AddInt32(&received, 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	// MsgChan is disabled, its unread buffer of 100 must not stop the reader
+	for i := 0; i < 250; i++ {
+		s.Emit(amiclient.Message{{Key: "Event", Value: "UserEvent"}})
+	}
+
+	if _, err = client.Do(ctx, amiclient.NewAction("Ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	for atomic.LoadInt32(&received) < 250 {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Expected 250 events, got %d", atomic.LoadInt32(&received))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestClient_MsgChanWithSubscription(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+	client := amiclient.New(s.ClientSettings())
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	sub, err := client.Subscribe(amiclient.Filter{Events: []string{"UserEvent"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	s.Emit(amiclient.Message{{Key: "Event", Value: "UserEvent"}})
+
+	select {
+	case <-sub.C():
+	case <-ctx.Done():
+		t.Fatal("Event not received by the subscription")
+	}
+
+	// MsgChan is still fed next to the subscriptions
+	for {
+		select {
+		case msg := <-client.MsgChan():
+			if msg.Get("Event") == "UserEvent" {
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("Event not received on MsgChan")
+		}
+	}
+}
+
+func TestClient_SharedMessages(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	s := startTestServer(t, nil)
+
+	settings := s.ClientSettings()
+	settings.DisableMsgChan = true
+
+	client := amiclient.New(settings)
+
+	err := client.Connect(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	subs := make([]*amiclient.Subscription, 2)
+
+	for i := range subs {
+		subs[i], err = client.Subscribe(amiclient.Filter{Events: []string{"UserEvent"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer subs[i].Unsubscribe()
+	}
+
+	// the unmatched VarSet events are recycled, the shared UserEvents must stay intact
+	const n = 50
+
+	for i := 0; i < n; i++ {
+		s.Emit(amiclient.Message{{Key: "Event", Value: "VarSet"}, {Key: "Value", Value: "noise"}})
+		s.Emit(amiclient.Message{{Key: "Event", Value: "UserEvent"}, {Key: "Seq", Value: strconv.Itoa(i)}})
+	}
+
+	for _, sub := range subs {
+		for i := 0; i < n; i++ {
+			select {
+			case msg := <-sub.C():
+				if msg.Get("Event") != "UserEvent" || msg.Get("Seq") != strconv.Itoa(i) {
+					t.Fatalf("Expected UserEvent %d, got %v", i, msg)
+				}
+			case <-ctx.Done():
+				t.Fatalf("UserEvent %d not received", i)
+			}
+		}
+	}
+}
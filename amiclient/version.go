@@ -0,0 +1,90 @@
+package amiclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const bannerPrefix = "Asterisk Call Manager/"
+
+// Version is the AMI protocol version announced in the greeting banner.
+// AMI 2.x ships with Asterisk 12 and 13, every later major release bumps the major version.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion parses a version like "2.10.5", missing parts are zero.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+
+	parts := strings.SplitN(s, ".", 3)
+	dst := []*int{&v.Major, &v.Minor, &v.Patch}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return Version{}, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+		}
+
+		*dst[i] = n
+	}
+
+	return v, nil
+}
+
+func (v Version) AtLeast(major, minor int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+
+	return v.Minor >= minor
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// ProtocolVersion returns the AMI version of the current connection.
+func (c *Client) ProtocolVersion() Version {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+
+	return c.version
+}
+
+// readBanner reads the greeting line Asterisk sends on connect, it has no blank line terminator.
+func (c *Client) readBanner(ctx context.Context) error {
+	timeout := c.settings.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = time.Second * 10
+	}
+
+	_, cancel := c.handshakeContext(ctx, timeout)
+	defer cancel()
+
+	line, _, err := c.connectionReader().ReadLine()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBanner, err)
+	}
+
+	banner := string(line)
+	if !strings.HasPrefix(banner, bannerPrefix) {
+		return fmt.Errorf("%w: %q", ErrInvalidBanner, banner)
+	}
+
+	version, err := ParseVersion(strings.TrimPrefix(banner, bannerPrefix))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBanner, err)
+	}
+
+	c.connMu.Lock()
+	c.version = version
+	c.connMu.Unlock()
+
+	return nil
+}
@@ -3,14 +3,32 @@ module github.com/Arten331/telephony
 go 1.20
 
 require (
-	github.com/Arten331/observability v0.0.0-20230531192752-e9c77955fe63 // indirect
-	github.com/CyCoreSystems/ari v4.8.4+incompatible // indirect
+	github.com/Arten331/observability v0.0.0-20230531192752-e9c77955fe63
+	github.com/CyCoreSystems/ari v4.8.4+incompatible
+	github.com/inconshreveable/log15 v2.16.0+incompatible
+	github.com/prometheus/client_golang v1.15.1
+	go.uber.org/zap v1.24.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/go-stack/stack v1.8.1 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
-	github.com/inconshreveable/log15 v2.16.0+incompatible // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/oklog/ulid v1.3.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.42.0 // indirect
+	github.com/prometheus/procfs v0.9.0 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	golang.org/x/net v0.7.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/term v0.8.0 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
 )